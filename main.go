@@ -1,18 +1,103 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
 
 	r "github.com/minond/calc/repl"
 )
 
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: calc [-format plain|json|tsv] [-e code | script]
+
+With no arguments, calc starts an interactive session. Given a script or
+code to evaluate, it runs every statement in it, printing their results, and
+exits with a non-zero status at the first one that fails.
+
+When the standard input is not a terminal, calc evaluates it a line at a
+time without prompts, carrying on past the end of a line left inside of
+parentheses or braces. It reports errors on the standard error and exits
+with a non-zero status if any line failed.
+
+In both cases, the )save file, )load file and )copy file commands save the
+bindings of the session to a workspace file and restore them from one.
+
+`)
+	flag.PrintDefaults()
+}
+
 func main() {
+	code := flag.String("e", "", "evaluate `code` and exit")
+	format := flag.String("format", "plain", "write results outside of an interactive session as `plain`, json or tsv")
+	flag.Usage = usage
+	flag.Parse()
+
 	repl := r.NewRepl(os.Stdin, os.Stdout)
+	if f, err := r.ParseFormat(*format); err != nil {
+		fmt.Fprintf(os.Stderr, "calc: %v\n", err)
+		os.Exit(2)
+	} else {
+		repl.SetFormat(f)
+	}
+
+	// An empty -e is still code to evaluate, so whether it was given is
+	// told by the flags that were set rather than by its value.
+	eval := false
+	flag.Visit(func(f *flag.Flag) {
+		eval = eval || f.Name == "e"
+	})
 
+	switch {
+	case eval && flag.NArg() > 0:
+		fmt.Fprintln(os.Stderr, "calc: -e cannot be combined with a script")
+		os.Exit(2)
+	case eval:
+		run(repl, "-e", *code)
+	case flag.NArg() == 1:
+		src, err := ioutil.ReadFile(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "calc: %v\n", err)
+			os.Exit(1)
+		}
+		run(repl, flag.Arg(0), string(src))
+	case flag.NArg() > 1:
+		flag.Usage()
+		os.Exit(2)
+	case !terminal(os.Stdin):
+		if !repl.Batch("stdin", os.Stderr) {
+			os.Exit(1)
+		}
+	default:
+		interactive(repl)
+	}
+}
+
+// terminal reports whether f is a terminal rather than a file or a pipe.
+func terminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// run evaluates a script, exiting on the first error.
+func run(repl *r.Repl, name, code string) {
+	if err := repl.Run(name, code); err != nil {
+		fmt.Fprint(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func interactive(repl *r.Repl) {
 	for repl.Running() {
 		repl.Write("? ")
 
-		input, _ := repl.Read()
+		input, err := repl.Read()
+		if err != nil {
+			repl.Write("\n")
+			return
+		}
+
 		switch input {
 		case "":
 			continue
@@ -20,7 +105,16 @@ func main() {
 			repl.Stop()
 		case "debug":
 			repl.SetDebug(!repl.Debug())
+		case "rtl":
+			repl.SetRightToLeft(!repl.RightToLeft())
 		default:
+			if r.IsCommand(input) {
+				if err := repl.Command(input); err != nil {
+					repl.Write("error: %v\n", err)
+				}
+				repl.Write("\n")
+				continue
+			}
 			repl.Eval(input)
 		}
 	}
@@ -1,20 +1,30 @@
 // Package parser parses the following grammar:
 //
 //
-//     expr = app
+//     expr = opdef
+//          | reduce
+//          | app
 //          | op
 //          | unit
 //          ;
 //
+//     opdef = id id id ":=" expr
+//           ;
+//
 //     op = expr id expr
 //        ;
 //
 //     app = id expr ( expr ) *
 //         ;
 //
+//     reduce = id ( "/" | "\\" ) expr
+//            ;
+//
 //     unit = group
+//          | lambda
 //          | num
 //          | arr
+//          | str
 //          | id
 //          ;
 //
@@ -24,13 +34,63 @@
 //     group = "(" expr ")"
 //           ;
 //
+//     lambda = "{" expr "}"
+//            ;
+//
 //     id = ?? valid identifier characters ??
 //
-//     num = ?? valid number characters ??
+//     num = real [ ( "j" | "J" ) real ]
+//         ;
+//
+//     real = [ "¯" ] ( digits [ "." digits ] | "." digits ) [ exp ]
+//          | [ "¯" ] "0" ( "x" | "b" ) ?? hexadecimal or binary digits ??
+//          ;
+//
+//     exp = ( "e" | "E" ) [ "+" | "-" | "¯" ] digits
+//         ;
+//
+//     digits = ?? 0 to 9, with "_" between digits ??
+//
+//     str = "'" ?? any characters, with "''" for a quote ?? "'"
 //
 //
 // Both `app` and `op` are context-sensitive. With the use of a reference to
 // the local environemnt, the parser can tell if it should continue parsing a
 // function application with a specific number of arguments or an infix
 // operator.
+//
+// The `op` rule is ambiguous on its own. Chains of infix operators are grouped
+// using the precedence and associativity attached to each operator in the
+// environment, so `1 + 2 * 3` parses as `1 + (2 * 3)` and `a + b + c` as
+// `(a + b) + c`. An environment in right-to-left mode gives every operator
+// the same precedence and groups to the right, as APL does.
+//
+// A `lambda` defines a function. Its body names the right argument `⍵` and,
+// if it uses `⍺` for a left argument, the function takes two arguments. An
+// `opdef` such as `a ± b := (a + b) , (a - b)` defines a new infix operator,
+// binding its left and right arguments to the names around the operator.
+// A function name with nothing after it, such as the `odd` in
+// `filter (odd) x`, is an `id` that refers to the function rather than an
+// application of it.
+//
+// Negative numbers are written with APL's high minus, as in `¯3`, since `-`
+// is the negation function. Numbers, names and operators do not need to be
+// separated by spaces, so `1+2` is the same as `1 + 2` and `x-1` the same as
+// `x - 1`. Names start with a letter and may have digits and symbols in
+// them, but end where the symbols that follow are the name of an operator or
+// function that is defined, so `a%%b` is a single name until an operator
+// `%%` is defined and `a %% b` after. A slash or backslash always ends a
+// name. A `j` separates the real and imaginary parts of a complex number, as
+// in `3j¯4`.
+//
+// A `str` is a string of characters between single quotes, such as
+// `'hello'`, and evaluates to an array of characters.
+//
+// A `reduce` is written with no space between the operator and the slash, as
+// in `+/ 1 2 3`, and folds any infix operator or dyadic function over an
+// array or generator. A backslash, `+\ 1 2 3`, produces a scan instead.
+// Reductions fold from the right, as APL does, so a generator has to be read
+// to its end first unless the operator is associative, like `+`, `×`, `⌊`
+// and `⌈`. Such a generator may have at most 1048576 items to be reduced and
+// 1024 to be scanned, and an infinite one is refused with an error.
 package parser
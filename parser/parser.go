@@ -1,7 +1,6 @@
 package parser
 
 import (
-	"errors"
 	"fmt"
 	"math/big"
 	"strings"
@@ -17,11 +16,59 @@ const (
 	tokEOF tok = iota
 	tokNum
 	tokWord
+	tokStr
+	tokSep
 )
 
+// Pos is a location in the input. Offset counts runes from the start of the
+// input, Line and Col start at 1.
+type Pos struct {
+	Offset int
+	Line   int
+	Col    int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// Span covers the input from Start up to, but not including, End.
+type Span struct {
+	Start Pos
+	End   Pos
+}
+
+func (s Span) String() string {
+	return s.Start.String()
+}
+
+func join(a, b Span) Span {
+	return Span{Start: a.Start, End: b.End}
+}
+
+// Error is a syntax error along with the part of the input it refers to.
+type Error struct {
+	Span Span
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Span, e.Msg)
+}
+
+func errorf(span Span, format string, a ...interface{}) *Error {
+	return &Error{Span: span, Msg: fmt.Sprintf(format, a...)}
+}
+
 type token struct {
 	tok    tok
 	lexeme string
+	span   Span
+}
+
+func (t token) at(span Span) token {
+	t.span = span
+	return t
 }
 
 func (t token) is(a tok) bool {
@@ -40,6 +87,10 @@ func (t token) String() string {
 		return fmt.Sprintf("(token-num `%s`)", t.lexeme)
 	case tokWord:
 		return fmt.Sprintf("(token-word `%s`)", t.lexeme)
+	case tokStr:
+		return fmt.Sprintf("(token-str %q)", t.lexeme)
+	case tokSep:
+		return fmt.Sprintf("(token-sep %q)", t.lexeme)
 	default:
 		return fmt.Sprintf("(token-unknown `%s`)", t.lexeme)
 	}
@@ -49,40 +100,107 @@ var (
 	tokenEOF        = token{tok: tokEOF}
 	tokenCloseParen = token{tok: tokWord, lexeme: ")"}
 	tokenOpenParen  = token{tok: tokWord, lexeme: "("}
+	tokenOpenBrace  = token{tok: tokWord, lexeme: "{"}
+	tokenCloseBrace = token{tok: tokWord, lexeme: "}"}
 )
 
-func tokenize(input string) []token {
+// tokenize splits input into tokens. In a script, newlines separate
+// statements as ⋄ does, while elsewhere they are spaces. Names end where an
+// operator or function of env starts, as word describes.
+func tokenize(input string, script bool, env *value.Environment) ([]token, error) {
 	runes := []rune(input)
 	max := len(runes)
 
+	// Position of every rune plus one past the end, so that a token's span
+	// can be looked up from its start and end offsets.
+	positions := make([]Pos, max+1)
+	line, col := 1, 1
+	for i := 0; i <= max; i++ {
+		positions[i] = Pos{Offset: i, Line: line, Col: col}
+		if i < max && runes[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+
+	span := func(start, end int) Span {
+		return Span{Start: positions[start], End: positions[end]}
+	}
+
 	var curr rune
 	var tokens []token
 
-	validchar := and(not(unicode.IsSpace), not(is(')')))
+	// Newlines do not separate statements inside of parentheses and braces.
+	depth := 0
 
 	for pos := 0; pos < max; {
 		curr = runes[pos]
 		switch {
+		case curr == '⋄' || curr == '\n' && script && depth == 0:
+			tokens = append(tokens, token{tok: tokSep, lexeme: string(curr), span: span(pos, pos+1)})
+			pos++
+		case curr == '#' && (pos == 0 || !wordchar(runes[pos-1])):
+			for pos < max && runes[pos] != '\n' {
+				pos++
+			}
 		case unicode.IsSpace(curr):
 			pos++
 		case curr == '(':
-			tokens = append(tokens, tokenOpenParen)
+			tokens = append(tokens, tokenOpenParen.at(span(pos, pos+1)))
+			depth++
 			pos++
 		case curr == ')':
-			tokens = append(tokens, tokenCloseParen)
+			tokens = append(tokens, tokenCloseParen.at(span(pos, pos+1)))
+			depth--
+			pos++
+		case curr == '{':
+			tokens = append(tokens, tokenOpenBrace.at(span(pos, pos+1)))
+			depth++
 			pos++
-		case unicode.IsNumber(curr):
-			num, size := eat(runes, pos, max, validchar)
+		case curr == '}':
+			tokens = append(tokens, tokenCloseBrace.at(span(pos, pos+1)))
+			depth--
+			pos++
+		case curr == '\'':
+			str, size, ok := quoted(runes, pos, max)
+			if !ok {
+				return nil, errorf(span(pos, max), "unterminated string")
+			}
+			tokens = append(tokens, token{tok: tokStr, lexeme: string(str), span: span(pos, pos+size)})
+			pos += size
+		case isNumStart(runes, pos, max):
+			size := number(runes, pos, max)
+			tokens = append(tokens, token{tok: tokNum, lexeme: string(runes[pos : pos+size]), span: span(pos, pos+size)})
 			pos += size
-			tokens = append(tokens, token{tok: tokNum, lexeme: string(num)})
 		default:
-			word, size := eat(runes, pos, max, validchar)
+			size := word(runes, pos, max, env)
+			tokens = append(tokens, token{tok: tokWord, lexeme: string(runes[pos : pos+size]), span: span(pos, pos+size)})
 			pos += size
-			tokens = append(tokens, token{tok: tokWord, lexeme: string(word)})
 		}
 	}
 
-	return tokens
+	tokens = append(tokens, tokenEOF.at(span(max, max)))
+	return tokens, nil
+}
+
+// quoted reads the string that starts with the quote at pos, returning its
+// contents and its size including the quotes. As in APL, a quote inside of a
+// string is written as two quotes.
+func quoted(runes []rune, pos, max int) ([]rune, int, bool) {
+	buff := []rune{}
+	for i := pos + 1; i < max; i++ {
+		if runes[i] != '\'' {
+			buff = append(buff, runes[i])
+		} else if i+1 < max && runes[i+1] == '\'' {
+			buff = append(buff, '\'')
+			i++
+		} else {
+			return buff, i + 1 - pos, true
+		}
+	}
+	return nil, 0, false
 }
 
 type runePred func(rune) bool
@@ -99,6 +217,17 @@ func not(fn runePred) runePred {
 	}
 }
 
+func or(fns ...runePred) runePred {
+	return func(r rune) bool {
+		for _, fn := range fns {
+			if fn(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
 func and(fns ...runePred) runePred {
 	return func(r rune) bool {
 		for _, fn := range fns {
@@ -110,23 +239,132 @@ func and(fns ...runePred) runePred {
 	}
 }
 
-func eat(runes []rune, pos, max int, pred runePred) ([]rune, int) {
-	buff := []rune{}
-	for ; pos < max; pos++ {
-		if !pred(runes[pos]) {
+var (
+	wordchar  = and(not(unicode.IsSpace), not(is('(')), not(is(')')), not(is('{')), not(is('}')), not(is('\'')), not(is('⋄')))
+	namestart = or(unicode.IsLetter, is('_'), is('⎕'), is('⍺'), is('⍵'))
+	digit     = func(r rune) bool { return r >= '0' && r <= '9' }
+	hexdigit  = func(r rune) bool { return digit(r) || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F' }
+)
+
+// word returns the size of the word at pos. A word that starts with a
+// letter, such as `x1` or `⎕IO`, is a name. It ends where the symbols that
+// follow one of its characters are the name of an operator or function of
+// env, so that `x-1` is split into three tokens, and so is `a%%b` once an
+// operator `%%` is defined. A slash or backslash always ends a name and
+// belongs to it, as in the reduction `f/`. A word made up of symbols ends
+// where a number or a name starts, so that `1+2` and `a+b` are split as
+// well.
+func word(runes []rune, pos, max int, env *value.Environment) int {
+	i := pos + 1
+	if !namestart(runes[pos]) {
+		return symbols(runes, pos, max)
+	}
+
+	for ; i < max && wordchar(runes[i]) && runes[i] != '/' && runes[i] != '\\'; i++ {
+		if namestart(runes[i]) || digit(runes[i]) {
+			continue
+		}
+		op := string(runes[i : i+symbols(runes, i, max)])
+		if env.HasOp(op) || env.HasFn(op) {
+			return i - pos
+		}
+	}
+	if i < max && (runes[i] == '/' || runes[i] == '\\') {
+		i++
+	}
+	return i - pos
+}
+
+// symbols returns the size of the word made up of symbols at pos, which
+// ends where a number or a name starts. A decimal point that follows
+// another one belongs to the word, as in `1..5`.
+func symbols(runes []rune, pos, max int) int {
+	i := pos + 1
+	for ; i < max && wordchar(runes[i]) && !namestart(runes[i]); i++ {
+		if !(runes[i] == '.' && runes[i-1] == '.') && isNumStart(runes, i, max) {
 			break
 		}
-		buff = append(buff, runes[pos])
 	}
-	return buff, len(buff)
+	return i - pos
+}
+
+// isNumStart reports whether a number starts at pos. Besides digits, numbers
+// may start with APL's high minus, `¯3`, or a decimal point, `.5`.
+func isNumStart(runes []rune, pos, max int) bool {
+	at := func(i int, pred runePred) bool {
+		return i < max && pred(runes[i])
+	}
+	if at(pos, is('¯')) {
+		pos++
+	}
+	return at(pos, digit) || at(pos, is('.')) && at(pos+1, digit)
+}
+
+// number returns the size of the number at pos. Numbers are made up of an
+// optional high minus followed by digits, which may be separated by
+// underscores, with an optional fraction and exponent, as in `¯1_000.5e¯3`.
+// A `0x` or `0b` prefix starts a hexadecimal or binary number, and a `j`
+// separates the real and imaginary parts of a complex number, as in `3j4`.
+func number(runes []rune, pos, max int) int {
+	i := pos
+	at := func(pred runePred) bool {
+		return i < max && pred(runes[i])
+	}
+	skip := func(pred runePred) {
+		for at(pred) {
+			i++
+		}
+	}
+
+	if at(is('¯')) {
+		i++
+	}
+
+	if at(is('0')) && i+1 < max && strings.ContainsRune("xXbB", runes[i+1]) {
+		i += 2
+		skip(or(hexdigit, is('_')))
+	} else {
+		skip(or(digit, is('_')))
+		if at(is('.')) && i+1 < max && digit(runes[i+1]) {
+			i++
+			skip(or(digit, is('_')))
+		}
+		if at(or(is('e'), is('E'))) {
+			j := i + 1
+			if j < max && strings.ContainsRune("+-¯", runes[j]) {
+				j++
+			}
+			if j < max && digit(runes[j]) {
+				i = j
+				skip(or(digit, is('_')))
+			}
+		}
+	}
+
+	if at(or(is('j'), is('J'))) {
+		i++
+		if isNumStart(runes, i, max) {
+			i += number(runes, i, max)
+		}
+	}
+
+	// Letters right after a number make it invalid rather than start a word.
+	skip(or(unicode.IsLetter, digit, is('_')))
+	return i - pos
 }
 
 type Expr interface {
 	Stringify(indent int) string
+	Span() Span
 }
 
 type Group struct {
 	Sub Expr
+	Loc Span
+}
+
+func (g Group) Span() Span {
+	return g.Loc
 }
 
 func (g Group) Stringify(indent int) string {
@@ -142,6 +380,11 @@ type Op struct {
 	Op  string
 	Lhs Expr
 	Rhs Expr
+	Loc Span
+}
+
+func (o Op) Span() Span {
+	return o.Loc
 }
 
 func (o Op) Stringify(indent int) string {
@@ -156,6 +399,11 @@ func (o Op) Stringify(indent int) string {
 type App struct {
 	Op   string
 	Args []Expr
+	Loc  Span
+}
+
+func (p App) Span() Span {
+	return p.Loc
 }
 
 func (p App) Stringify(indent int) string {
@@ -171,6 +419,11 @@ func (p App) Stringify(indent int) string {
 
 type Arr struct {
 	Values []*Num
+	Loc    Span
+}
+
+func (a Arr) Span() Span {
+	return a.Loc
 }
 
 func (a Arr) Stringify(indent int) string {
@@ -184,26 +437,149 @@ func (a Arr) Stringify(indent int) string {
 	return fmt.Sprintf("(array%s%s)", pad, left)
 }
 
+// Num is a number literal. In exact mode, Rat holds its exact value. Imag is
+// the imaginary part of a complex number, whose real part is Value.
 type Num struct {
 	Value *big.Float
+	Rat   *big.Rat
+	Imag  *big.Float
+	Loc   Span
+}
+
+func (n Num) Span() Span {
+	return n.Loc
 }
 
 func (n Num) Stringify(indent int) string {
+	if n.Imag != nil {
+		return fmt.Sprintf("(num %sJ%s)", n.Value.String(), n.Imag.String())
+	}
 	return fmt.Sprintf("(num %s)", n.Value.String())
 }
 
 type Id struct {
 	Value string
+	Loc   Span
+}
+
+func (i Id) Span() Span {
+	return i.Loc
 }
 
 func (i Id) Stringify(indent int) string {
 	return fmt.Sprintf("(id %s)", i.Value)
 }
 
+// Str is a string literal, which evaluates to an array of characters.
+type Str struct {
+	Value string
+	Loc   Span
+}
+
+func (s Str) Span() Span {
+	return s.Loc
+}
+
+func (s Str) Stringify(indent int) string {
+	return fmt.Sprintf("(str %q)", s.Value)
+}
+
+// Lambda is a function defined in the language. Its body refers to the right
+// argument as ⍵ and, when it takes two arguments, to the left one as ⍺.
+type Lambda struct {
+	Argc int
+	Body Expr
+	Src  string
+	Loc  Span
+}
+
+func (l Lambda) Span() Span {
+	return l.Loc
+}
+
+func (l Lambda) Stringify(indent int) string {
+	return fmt.Sprintf("(lambda/%d\n%s%s)",
+		l.Argc,
+		strings.Repeat(" ", indent+2),
+		l.Body.Stringify(indent+2))
+}
+
+// OpDef defines an infix operator. Its body refers to the left and right
+// arguments by the names given in Lhs and Rhs.
+type OpDef struct {
+	Op   string
+	Lhs  string
+	Rhs  string
+	Body Expr
+	Src  string
+	Loc  Span
+}
+
+func (d OpDef) Span() Span {
+	return d.Loc
+}
+
+func (d OpDef) Stringify(indent int) string {
+	pad := strings.Repeat(" ", indent+2)
+	return fmt.Sprintf("(opdef %s\n%s(id %s)\n%s(id %s)\n%s%s)",
+		d.Op,
+		pad, d.Lhs,
+		pad, d.Rhs,
+		pad, d.Body.Stringify(indent+2))
+}
+
+// Reduce folds a dyadic operator or function over its argument. With Scan
+// set it produces every intermediate result of the fold instead.
+type Reduce struct {
+	Op   string
+	Scan bool
+	Arg  Expr
+	Loc  Span
+}
+
+func (r Reduce) Span() Span {
+	return r.Loc
+}
+
+func (r Reduce) Stringify(indent int) string {
+	kind := "reduce"
+	if r.Scan {
+		kind = "scan"
+	}
+	return fmt.Sprintf("(%s %s\n%s%s)",
+		kind,
+		r.Op,
+		strings.Repeat(" ", indent+2),
+		r.Arg.Stringify(indent+2))
+}
+
+// refers reports whether expr mentions the identifier id outside of any
+// nested lambda.
+func refers(expr Expr, id string) bool {
+	switch e := expr.(type) {
+	case *Id:
+		return e.Value == id
+	case *Group:
+		return e.Sub != nil && refers(e.Sub, id)
+	case *Op:
+		return refers(e.Lhs, id) || refers(e.Rhs, id)
+	case *App:
+		for _, arg := range e.Args {
+			if refers(arg, id) {
+				return true
+			}
+		}
+	case *Reduce:
+		return refers(e.Arg, id)
+	}
+	return false
+}
+
 type Parser struct {
 	env *value.Environment
 
 	mux    sync.Mutex
+	input  []rune
 	tokens []token
 	pos    int
 }
@@ -212,12 +588,112 @@ func NewParser(env *value.Environment) *Parser {
 	return &Parser{env: env}
 }
 
+// Parse parses a single expression. Syntax errors are returned as *Error
+// values which point at the offending part of the input.
 func (p *Parser) Parse(input string) (Expr, error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
-	p.tokens = tokenize(input)
+	p.input = []rune(input)
+	p.pos = 0
+
+	tokens, err := tokenize(input, false, p.env)
+	if err != nil {
+		return nil, err
+	}
+	p.tokens = tokens
+
+	expr, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	if next := p.peek(); !next.is(tokEOF) {
+		return nil, errorf(next.span, "unexpected %s", next)
+	}
+	return expr, nil
+}
+
+// Statements starts parsing a script, made up of statements that are
+// separated by newlines or ⋄, which are then parsed one at a time by Next.
+// Since parsing depends on the functions and operators that are defined,
+// every statement should be evaluated before the next one is parsed.
+func (p *Parser) Statements(input string) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.input = []rune(input)
 	p.pos = 0
-	return p.expr()
+
+	tokens, err := tokenize(input, true, p.env)
+	if err != nil {
+		return err
+	}
+	p.tokens = tokens
+	return nil
+}
+
+// Open reports whether input ends inside of parentheses or braces, where a
+// statement of a script carries on past the end of the line.
+func Open(input string) bool {
+	tokens, err := tokenize(input, true, nil)
+	if err != nil {
+		return false
+	}
+
+	depth := 0
+	for _, t := range tokens {
+		switch {
+		case t.eqv(tokenOpenParen) || t.eqv(tokenOpenBrace):
+			depth++
+		case t.eqv(tokenCloseParen) || t.eqv(tokenCloseBrace):
+			depth--
+		}
+	}
+	return depth > 0
+}
+
+// Next parses the next statement of the script passed to Statements,
+// skipping empty ones. It returns nil once there are no statements left.
+// Spans refer to the script as a whole.
+func (p *Parser) Next() (Expr, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for p.peek().is(tokSep) {
+		p.eat()
+	}
+	if p.peek().is(tokEOF) {
+		return nil, nil
+	} else if err := p.retokenize(); err != nil {
+		return nil, err
+	}
+
+	expr, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	if next := p.peek(); !next.is(tokEOF) && !next.is(tokSep) {
+		return nil, errorf(next.span, "unexpected %s", next)
+	}
+	return expr, nil
+}
+
+// retokenize splits the rest of the script into tokens again, starting at
+// the current token, since the statements before it may have defined
+// operators that split the names in it.
+func (p *Parser) retokenize() error {
+	start := p.peek().span.Start.Offset
+	tokens, err := tokenize(string(p.input), true, p.env)
+	if err != nil {
+		return err
+	}
+	for i, t := range tokens {
+		if t.span.Start.Offset >= start {
+			p.tokens, p.pos = tokens, i
+			return nil
+		}
+	}
+	return nil
 }
 
 func (p *Parser) isOp(op string) bool {
@@ -231,11 +707,38 @@ func (p *Parser) isFn(fn string) (int, bool) {
 	return 0, false
 }
 
+// isReduce reports whether word is a reduction, `op/`, or a scan, `op\`,
+// of an infix operator or a function that takes two arguments.
+func (p *Parser) isReduce(word string) (string, bool, bool) {
+	if p.isOp(word) || p.env.HasFn(word) {
+		return "", false, false
+	}
+
+	runes := []rune(word)
+	if len(runes) < 2 {
+		return "", false, false
+	}
+
+	last := runes[len(runes)-1]
+	if last != '/' && last != '\\' {
+		return "", false, false
+	}
+
+	op := string(runes[:len(runes)-1])
+	if argc, ok := p.isFn(op); !p.isOp(op) && (!ok || argc != 2) {
+		return "", false, false
+	}
+	return op, last == '\\', true
+}
+
+// lookahead returns the token n places ahead of the current one. The token
+// list always ends with an EOF token, which is returned for anything past
+// the end of the input.
 func (p *Parser) lookahead(n int) token {
 	if p.pos+n < len(p.tokens) {
 		return p.tokens[p.pos+n]
 	}
-	return tokenEOF
+	return p.tokens[len(p.tokens)-1]
 }
 
 func (p *Parser) peek() token {
@@ -249,7 +752,7 @@ func (p *Parser) eat() token {
 }
 
 func (p *Parser) done() bool {
-	return p.pos >= len(p.tokens)
+	return p.peek().is(tokEOF) || p.peek().is(tokSep)
 }
 
 // expr = app
@@ -257,43 +760,137 @@ func (p *Parser) done() bool {
 //      | unit
 //      ;
 func (p *Parser) expr() (Expr, error) {
-	if p.done() {
-		return nil, errors.New("unexpected eof")
+	return p.infix(0)
+}
+
+// infix parses an operand followed by any infix operators that bind tighter
+// than min. Precedence and associativity come from the environment, which
+// lets the same parser handle both conventional and right-to-left grouping.
+//
+// op = expr id expr
+//    ;
+func (p *Parser) infix(min int) (Expr, error) {
+	expr, err := p.prefix()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOp(p.peek().lexeme) {
+		prec, assoc := p.env.Binding(p.peek().lexeme)
+		if prec <= min {
+			break
+		}
+
+		op := p.eat()
+		next := prec
+		if assoc == value.AssocRight {
+			next = prec - 1
+		}
+
+		rhs, err := p.infix(next)
+		if err != nil {
+			return nil, err
+		}
+		expr = &Op{Op: op.lexeme, Lhs: expr, Rhs: rhs, Loc: join(expr.Span(), rhs.Span())}
+	}
+
+	return expr, nil
+}
+
+// app = id expr ( expr ) *
+//     ;
+//
+// reduce = id ( "/" | "\\" ) expr
+//        ;
+func (p *Parser) prefix() (Expr, error) {
+	if next := p.peek(); next.is(tokSep) {
+		return nil, errorf(next.span, "unexpected end of statement")
+	} else if p.done() {
+		return nil, errorf(next.span, "unexpected eof")
 	}
 
+	if p.isOpDef() {
+		return p.opdef()
+	}
+
+	// A function name followed by an assignment is being redefined rather
+	// than applied.
 	next := p.peek()
+	if p.lookahead(1).lexeme == ":=" {
+		return p.unit()
+	}
+
+	if op, scan, ok := p.isReduce(next.lexeme); ok {
+		p.eat()
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return &Reduce{Op: op, Scan: scan, Arg: arg, Loc: join(next.span, arg.Span())}, nil
+	}
+
+	// A function name with nothing to apply it to refers to the function
+	// itself, so that it can be passed to another one, as in `filter (odd) x`.
+	if argc, ok := p.isFn(next.lexeme); ok && argc > 0 && p.isEnd(p.lookahead(1)) {
+		op := p.eat()
+		return &Id{Value: op.lexeme, Loc: op.span}, nil
+	}
+
 	if argc, ok := p.isFn(next.lexeme); ok {
 		op := p.eat()
-		var args []Expr
+		app := &App{Op: op.lexeme, Loc: op.span}
 		for ; argc > 0; argc-- {
 			arg, err := p.expr()
 			if err != nil {
 				return nil, err
 			}
-			args = append(args, arg)
+			app.Args = append(app.Args, arg)
+			app.Loc = join(app.Loc, arg.Span())
 		}
-		return &App{Op: op.lexeme, Args: args}, nil
+		return app, nil
 	}
 
-	expr, err := p.unit()
-	if err != nil {
-		return nil, err
-	}
+	return p.unit()
+}
 
-	if p.isOp(p.peek().lexeme) {
-		op := p.eat()
-		rhs, err := p.expr()
-		if err != nil {
-			return nil, err
+// isEnd reports whether tok ends an expression.
+func (p *Parser) isEnd(tok token) bool {
+	return tok.is(tokEOF) || tok.is(tokSep) || tok.eqv(tokenCloseParen) || tok.eqv(tokenCloseBrace)
+}
+
+// isOpDef reports whether the next tokens are the head of an operator
+// definition: three plain words followed by an assignment.
+func (p *Parser) isOpDef() bool {
+	for i := 0; i < 3; i++ {
+		next := p.lookahead(i)
+		if !next.is(tokWord) || next.eqv(tokenOpenParen) || next.eqv(tokenCloseParen) ||
+			next.eqv(tokenOpenBrace) || next.eqv(tokenCloseBrace) || next.lexeme == ":=" {
+			return false
 		}
-		expr = &Op{Op: op.lexeme, Lhs: expr, Rhs: rhs}
-		return expr, nil
+	}
+	return p.lookahead(3).lexeme == ":="
+}
+
+// opdef = id id id ":=" expr
+//       ;
+func (p *Parser) opdef() (Expr, error) {
+	lhs := p.eat()
+	op := p.eat()
+	rhs := p.eat()
+	p.eat()
+
+	body, err := p.expr()
+	if err != nil {
+		return nil, err
 	}
 
-	return expr, err
+	loc := join(lhs.span, body.Span())
+	src := string(p.input[loc.Start.Offset:loc.End.Offset])
+	return &OpDef{Op: op.lexeme, Lhs: lhs.lexeme, Rhs: rhs.lexeme, Body: body, Src: src, Loc: loc}, nil
 }
 
 // unit = group
+//      | lambda
 //      | num
 //      | arr
 //      | id
@@ -301,13 +898,19 @@ func (p *Parser) expr() (Expr, error) {
 func (p *Parser) unit() (Expr, error) {
 	next := p.peek()
 	if next.eqv(tokenCloseParen) {
-		return nil, nil
+		return nil, errorf(next.span, "unexpected closing paren")
+	} else if next.eqv(tokenCloseBrace) {
+		return nil, errorf(next.span, "unexpected closing brace")
 	} else if next.eqv(tokenOpenParen) {
 		return p.group()
+	} else if next.eqv(tokenOpenBrace) {
+		return p.lambda()
 	} else if next.is(tokNum) && p.lookahead(1).is(tokNum) {
 		return p.arr()
 	} else if next.is(tokNum) {
 		return p.num()
+	} else if next.is(tokStr) {
+		return p.str()
 	}
 	return p.id()
 }
@@ -315,9 +918,14 @@ func (p *Parser) unit() (Expr, error) {
 // group = "(" expr ")"
 //       ;
 func (p *Parser) group() (Expr, error) {
-	next := p.eat()
-	if !next.eqv(tokenOpenParen) {
-		return nil, fmt.Errorf("expecting an open paren but got %s instead", next)
+	open := p.eat()
+	if !open.eqv(tokenOpenParen) {
+		return nil, errorf(open.span, "expecting an open paren but got %s instead", open)
+	}
+
+	if next := p.peek(); next.eqv(tokenCloseParen) {
+		p.eat()
+		return &Group{Loc: join(open.span, next.span)}, nil
 	}
 
 	sub, err := p.expr()
@@ -325,40 +933,127 @@ func (p *Parser) group() (Expr, error) {
 		return nil, err
 	}
 
-	next = p.eat()
+	next := p.eat()
 	if !next.eqv(tokenCloseParen) {
-		return nil, fmt.Errorf("expecting a closing paren but got %s instead", next)
+		return nil, errorf(next.span, "expecting a closing paren but got %s instead", next)
 	}
 
-	return &Group{Sub: sub}, nil
+	return &Group{Sub: sub, Loc: join(open.span, next.span)}, nil
+}
+
+// lambda = "{" expr "}"
+//        ;
+func (p *Parser) lambda() (Expr, error) {
+	open := p.eat()
+	if !open.eqv(tokenOpenBrace) {
+		return nil, errorf(open.span, "expecting an open brace but got %s instead", open)
+	}
+
+	body, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	next := p.eat()
+	if !next.eqv(tokenCloseBrace) {
+		return nil, errorf(next.span, "expecting a closing brace but got %s instead", next)
+	}
+
+	argc := 1
+	if refers(body, "⍺") {
+		argc = 2
+	}
+
+	loc := join(open.span, next.span)
+	src := string(p.input[loc.Start.Offset:loc.End.Offset])
+	return &Lambda{Argc: argc, Body: body, Src: src, Loc: loc}, nil
 }
 
 func (p *Parser) id() (Expr, error) {
 	id := p.eat()
-	return &Id{id.lexeme}, nil
+	return &Id{Value: id.lexeme, Loc: id.span}, nil
 }
 
 func (p *Parser) arr() (Expr, error) {
-	arr := &Arr{}
+	arr := &Arr{Loc: p.peek().span}
 	for p.peek().is(tokNum) {
 		val, err := p.num()
 		if err != nil {
 			return nil, err
 		}
 		arr.Values = append(arr.Values, val)
+		arr.Loc = join(arr.Loc, val.Loc)
 	}
 	return arr, nil
 }
 
+func (p *Parser) str() (Expr, error) {
+	str := p.eat()
+	return &Str{Value: str.lexeme, Loc: str.span}, nil
+}
+
 func (p *Parser) num() (*Num, error) {
 	next := p.eat()
 	if !next.is(tokNum) {
-		return nil, fmt.Errorf("expecting a number but got %s instead", next)
+		return nil, errorf(next.span, "expecting a number but got %s instead", next)
 	}
 
-	value, _, err := big.ParseFloat(next.lexeme, 10, 0, big.ToNearestEven)
-	if err != nil {
-		return nil, fmt.Errorf("unable to parse number: %v", err)
+	float := func(r *big.Rat) *big.Float {
+		return new(big.Float).SetPrec(p.env.Precision()).SetMode(p.env.RoundingMode()).SetRat(r)
+	}
+
+	// Complex numbers are never exact.
+	if i := strings.IndexAny(next.lexeme, "jJ"); i >= 0 {
+		re, ok := parseNum(next.lexeme[:i])
+		if !ok {
+			return nil, errorf(next.span, "invalid number %s", next.lexeme)
+		}
+		im, ok := parseNum(next.lexeme[i+1:])
+		if !ok {
+			return nil, errorf(next.span, "invalid number %s", next.lexeme)
+		}
+		return &Num{Value: float(re), Imag: float(im), Loc: next.span}, nil
+	}
+
+	exact, ok := parseNum(next.lexeme)
+	if !ok {
+		return nil, errorf(next.span, "invalid number %s", next.lexeme)
+	}
+
+	num := &Num{Value: float(exact), Loc: next.span}
+	if p.env.Exact() {
+		num.Rat = exact
+	}
+	return num, nil
+}
+
+// parseNum converts the lexeme of a number into its exact value.
+func parseNum(lexeme string) (*big.Rat, bool) {
+	neg := strings.HasPrefix(lexeme, "¯")
+	lexeme = strings.TrimPrefix(lexeme, "¯")
+	if strings.HasPrefix(lexeme, "_") || strings.HasSuffix(lexeme, "_") || strings.Contains(lexeme, "__") {
+		return nil, false
+	}
+	lexeme = strings.Replace(lexeme, "_", "", -1)
+	lexeme = strings.Replace(lexeme, "¯", "-", -1)
+
+	value := new(big.Rat)
+	if len(lexeme) > 2 && lexeme[0] == '0' && strings.ContainsAny(lexeme[1:2], "xXbB") {
+		base := 16
+		if strings.ContainsAny(lexeme[1:2], "bB") {
+			base = 2
+		}
+		i, ok := new(big.Int).SetString(lexeme[2:], base)
+		if !ok {
+			return nil, false
+		}
+		value.SetInt(i)
+	} else if _, ok := value.SetString(lexeme); !ok || strings.ContainsAny(lexeme, "/xXbBpP") {
+		return nil, false
+	}
+
+	if neg {
+		value.Neg(value)
 	}
-	return &Num{Value: value}, nil
+	return value, true
 }
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/minond/calc/value"
@@ -14,20 +15,65 @@ func TestParse(t *testing.T) {
 	}{
 		{"number", "1", "(num 1)"},
 		{"long number", "78934430289340", "(num 7.893443029e+13)"},
+		{"negative number", "¯3", "(num -3)"},
+		{"fraction", "1.5", "(num 1.5)"},
+		{"fraction without an integer part", ".5", "(num 0.5)"},
+		{"exponent", "1.5e3", "(num 1500)"},
+		{"negative exponent", "25e¯2", "(num 0.25)"},
+		{"hexadecimal number", "0xff", "(num 255)"},
+		{"binary number", "0b101", "(num 5)"},
+		{"digit separators", "1_000_000", "(num 1000000)"},
+		{"comment", "1 + 2 # three", "(op +\n  (num 1)\n  (num 2))"},
+		{"hash inside of an identifier", "a#b", "(id a#b)"},
+		{"hash inside of a string", "'#'", "(str \"#\")"},
+		{"complex number", "3j4", "(num 3J4)"},
+		{"complex number with negative parts", "¯1.5J¯2", "(num -1.5J-2)"},
+		{"array with negative numbers", "1 ¯2", "(array\n  (num 1)\n  (num -2))"},
+		{"infix expression without spaces", "1+2", "(op +\n  (num 1)\n  (num 2))"},
+		{"range without spaces", "1..5", "(op ..\n  (num 1)\n  (num 5))"},
+		{"reduction without spaces", "+/1 2", "(reduce +\n  (array\n    (num 1)\n    (num 2)))"},
+		{"identifier with digits", "x1", "(id x1)"},
 		{"identifier", "a", "(id a)"},
 		{"long identifier", "jfkdlsa$%%@$@#", "(id jfkdlsa$%%@$@#)"},
+		{"infix expression with identifiers and no spaces", "a+b", "(op +\n  (id a)\n  (id b))"},
+		{"identifier minus a number without spaces", "x-1", "(op -\n  (id x)\n  (num 1))"},
+		{"assignment without spaces", "x:=1", "(op :=\n  (id x)\n  (num 1))"},
+		{"system variable without spaces", "⎕IO+1", "(op +\n  (id ⎕IO)\n  (num 1))"},
+		{"lambda without spaces", "{⍺+⍵}", "(lambda/2\n  (op +\n    (id ⍺)\n    (id ⍵)))"},
+		{"function application without spaces", "abs(1)", "(app abs\n  (group\n    (num 1)))"},
+		{"range with identifiers and no spaces", "a..b", "(op ..\n  (id a)\n  (id b))"},
+		{"defined operator without spaces", "a%%b", "(op %%\n  (id a)\n  (id b))"},
 		{"empty group", "()", "(group empty)"},
 		{"nested empty group", "((()))", "(group\n  (group\n    (group empty)))"},
 		{"prefix expression for number", "abs 1", "(app abs\n  (num 1))"},
 		{"prefix expression for identifier", "abs abc", "(app abs\n  (id abc))"},
+		{"function reference", "abs", "(id abs)"},
+		{"string", "'hello'", "(str \"hello\")"},
+		{"string with spaces", "'a b'", "(str \"a b\")"},
+		{"string with a quote", "'it''s'", "(str \"it's\")"},
+		{"empty string", "''", "(str \"\")"},
+		{"string argument", "len'abc'", "(app len\n  (str \"abc\"))"},
+		{"function reference in a group", "(abs) + 1", "(op +\n  (group\n    (id abs))\n  (num 1))"},
 		{"infix expression", "1 + 2", "(op +\n  (num 1)\n  (num 2))"},
-		{"multiple infix expressions", "1 + 2 + 3 + 4 + 5", "(op +\n  (num 1)\n  (op +\n    (num 2)\n    (op +\n      (num 3)\n      (op +\n        (num 4)\n        (num 5)))))"},
+		{"multiple infix expressions", "1 + 2 + 3 + 4 + 5", "(op +\n  (op +\n    (op +\n      (op +\n        (num 1)\n        (num 2))\n      (num 3))\n    (num 4))\n  (num 5))"},
+		{"higher precedence on the right", "1 + 2 * 3", "(op +\n  (num 1)\n  (op *\n    (num 2)\n    (num 3)))"},
+		{"higher precedence on the left", "1 * 2 + 3", "(op +\n  (op *\n    (num 1)\n    (num 2))\n  (num 3))"},
+		{"group overrides precedence", "(1 + 2) * 3", "(op *\n  (group\n    (op +\n      (num 1)\n      (num 2)))\n  (num 3))"},
+		{"monadic lambda", "{ ⍵ + 1 }", "(lambda/1\n  (op +\n    (id ⍵)\n    (num 1)))"},
+		{"dyadic lambda", "{ ⍺ + ⍵ }", "(lambda/2\n  (op +\n    (id ⍺)\n    (id ⍵)))"},
+		{"nested lambda does not add arguments", "{ { ⍺ } }", "(lambda/1\n  (lambda/2\n    (id ⍺)))"},
+		{"operator definition", "a ± b := a + b", "(opdef ±\n  (id a)\n  (id b)\n  (op +\n    (id a)\n    (id b)))"},
+		{"reduction", "+/ 1 2", "(reduce +\n  (array\n    (num 1)\n    (num 2)))"},
+		{"scan", "+\\ a", "(scan +\n  (id a))"},
+		{"reduction in a lambda", "{ +/ ⍺ }", "(lambda/2\n  (reduce +\n    (id ⍺)))"},
+		{"assignment binds loosest", "a := 1 + 2", "(op :=\n  (id a)\n  (op +\n    (num 1)\n    (num 2)))"},
 		{"infix with an identifier and a number", "a + 1", "(op +\n  (id a)\n  (num 1))"},
 		{"infix with a number and an identifier", "1 + a", "(op +\n  (num 1)\n  (id a))"},
 		{"infix with two identifiers", "a + b", "(op +\n  (id a)\n  (id b))"},
 	}
 
 	e := value.NewEnvironment()
+	e.SetOp("%%", &value.Op{})
 	p := NewParser(e)
 
 	for _, test := range tests {
@@ -42,3 +88,143 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRightToLeft(t *testing.T) {
+	tests := []struct {
+		label  string
+		input  string
+		output string
+	}{
+		{"equal precedence", "1 * 2 + 3", "(op *\n  (num 1)\n  (op +\n    (num 2)\n    (num 3)))"},
+		{"groups to the right", "1 + 2 + 3", "(op +\n  (num 1)\n  (op +\n    (num 2)\n    (num 3)))"},
+		{"assignment binds loosest", "a := 1 * 2 + 3", "(op :=\n  (id a)\n  (op *\n    (num 1)\n    (op +\n      (num 2)\n      (num 3))))"},
+	}
+
+	e := value.NewEnvironment()
+	e.SetRightToLeft(true)
+	p := NewParser(e)
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			ast, err := p.Parse(test.input)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if ast.Stringify(0) != test.output {
+				t.Errorf("invalid ast for `%s`:\nexpected: %s\nreturned: %s",
+					test.input, test.output, ast.Stringify(0))
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		label  string
+		input  string
+		output string
+	}{
+		{"unclosed group", "(1 + 2", "1:7: expecting a closing paren but got (token-eof) instead"},
+		{"missing operand", "1 +", "1:4: unexpected eof"},
+		{"stray closing paren", "1 2 3 )", "1:7: unexpected (token-word `)`)"},
+		{"error on a later line", "1 +\n  )", "2:3: unexpected closing paren"},
+		{"unterminated string", "1 , 'abc", "1:5: unterminated string"},
+		{"invalid hexadecimal number", "1 + 0xfg", "1:5: invalid number 0xfg"},
+		{"misplaced digit separator", "1__000", "1:1: invalid number 1__000"},
+		{"complex number without an imaginary part", "3j", "1:1: invalid number 3j"},
+		{"statement separator", "1 ⋄ 2", "1:3: unexpected (token-sep \"⋄\")"},
+		{"missing operand before a separator", "1 + ⋄ 2", "1:5: unexpected end of statement"},
+	}
+
+	e := value.NewEnvironment()
+	p := NewParser(e)
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			_, err := p.Parse(test.input)
+			if err == nil {
+				t.Errorf("expected an error for `%s`", test.input)
+			} else if err.Error() != test.output {
+				t.Errorf("invalid error for `%s`:\nexpected: %s\nreturned: %s",
+					test.input, test.output, err.Error())
+			}
+		})
+	}
+}
+
+func TestStatements(t *testing.T) {
+	tests := []struct {
+		label  string
+		input  string
+		output []string
+	}{
+		{"single statement", "1 + 2", []string{"(op +\n  (num 1)\n  (num 2))"}},
+		{"statements on separate lines", "1\n2\n", []string{"(num 1)", "(num 2)"}},
+		{"statements on the same line", "1 ⋄ 2", []string{"(num 1)", "(num 2)"}},
+		{"empty statements", "\n\n1 ⋄⋄ 2\n\n", []string{"(num 1)", "(num 2)"}},
+		{"comments", "# sum\n1 + 2 # three\n", []string{"(op +\n  (num 1)\n  (num 2))"}},
+		{"newlines inside of a group", "(1 +\n 2)", []string{"(group\n  (op +\n    (num 1)\n    (num 2)))"}},
+		{"separator inside of a string", "'a⋄b'", []string{"(str \"a⋄b\")"}},
+		{"only comments", "# nothing\n", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			p := NewParser(value.NewEnvironment())
+			if err := p.Statements(test.input); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var output []string
+			for {
+				expr, err := p.Next()
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				} else if expr == nil {
+					break
+				}
+				output = append(output, expr.Stringify(0))
+			}
+
+			if strings.Join(output, "\n") != strings.Join(test.output, "\n") {
+				t.Errorf("invalid statements for `%s`:\nexpected: %s\nreturned: %s",
+					test.input, test.output, output)
+			}
+		})
+	}
+}
+
+func TestStatementErrors(t *testing.T) {
+	p := NewParser(value.NewEnvironment())
+	if err := p.Statements("1\n2 +\n3"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := p.Next(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err := p.Next()
+	if err == nil || err.Error() != "2:4: unexpected end of statement" {
+		t.Errorf("invalid error: %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		input string
+		open  bool
+	}{
+		{"1 + 2", false},
+		{"(1 +", true},
+		{"f := {", true},
+		{"{(⍵", true},
+		{"(1 + 2)", false},
+		{"'(' , 1", false},
+		{"1 # (", false},
+		{"1)", false},
+	}
+
+	for _, test := range tests {
+		if open := Open(test.input); open != test.open {
+			t.Errorf("expecting Open(%q) to be %v", test.input, test.open)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package repl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/minond/calc/value"
+)
+
+// Format is how results are written outside of an interactive session.
+type Format uint8
+
+const (
+	// Plain writes results the way the interactive session shows them.
+	Plain Format = iota
+
+	// JSON writes every result as a JSON value on a line of its own, for
+	// tools such as jq. Arrays are nested by axis, strings are JSON strings,
+	// and numbers are encoded at full precision by value.EncodeJSON.
+	JSON
+
+	// TSV writes every result as lines of tab separated items, for tools
+	// such as awk. Vectors are a single line, and matrices a line per row.
+	TSV
+)
+
+var formats = map[string]Format{
+	"plain": Plain,
+	"json":  JSON,
+	"tsv":   TSV,
+}
+
+// ParseFormat returns the format called name.
+func ParseFormat(name string) (Format, error) {
+	format, ok := formats[name]
+	if !ok {
+		return Plain, fmt.Errorf("unknown format %q, expecting plain, json or tsv", name)
+	}
+	return format, nil
+}
+
+// format renders val in the given format, without a trailing newline.
+func format(env *value.Environment, val value.Value, format Format) (string, error) {
+	switch format {
+	case JSON:
+		data, err := jsonOf(val)
+		if err != nil {
+			return "", err
+		}
+		b, err := json.Marshal(data)
+		return string(b), err
+	case TSV:
+		return tsvOf(env, val), nil
+	}
+	return value.Format(env, val), nil
+}
+
+// jsonOf converts val into the values encoding/json marshals it as.
+func jsonOf(val value.Value) (interface{}, error) {
+	switch v := val.(type) {
+	case *value.Char:
+		return string(v.Value), nil
+	case *value.Arr:
+		dims := v.Dims()
+		if v.Rank() == 0 {
+			return jsonOf(v.At(0))
+		} else if str, ok := text(v); ok {
+			// Strings of a higher rank are lists of their rows.
+			return nest(rows(str, dims[len(dims)-1]), dims[:len(dims)-1]), nil
+		}
+		items := make([]interface{}, v.Len())
+		for i, item := range v.Items() {
+			data, err := jsonOf(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = data
+		}
+		return nest(items, dims), nil
+	}
+
+	data, err := value.EncodeJSON(val)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(data), nil
+}
+
+// nest splits the items of an array in row-major order into a list for
+// every axis, leaving the only item of an array without axes as it is.
+func nest(items []interface{}, dims []int) interface{} {
+	if len(dims) == 0 {
+		return items[0]
+	} else if len(dims) == 1 {
+		return items
+	}
+
+	list := make([]interface{}, dims[0])
+	size := len(items) / max(dims[0], 1)
+	for i := range list {
+		list[i] = nest(items[i*size:(i+1)*size], dims[1:])
+	}
+	return list
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// tsvOf lays val out as rows of tab separated items, with a row for every
+// vector along the last axis of an array.
+func tsvOf(env *value.Environment, val value.Value) string {
+	arr, ok := val.(*value.Arr)
+	if !ok || arr.Rank() == 0 {
+		return value.Format(env, val)
+	} else if str, ok := text(arr); ok && arr.Rank() == 1 {
+		return str
+	}
+
+	dims := arr.Dims()
+	cols := dims[len(dims)-1]
+	var rows []string
+	for r := 0; cols > 0 && r*cols < arr.Len(); r++ {
+		row := make([]string, cols)
+		for c := range row {
+			row[c] = value.Format(env, arr.At(r*cols+c))
+		}
+		rows = append(rows, strings.Join(row, "\t"))
+	}
+	return strings.Join(rows, "\n")
+}
+
+// rows splits str into rows of cols characters.
+func rows(str string, cols int) []interface{} {
+	runes := []rune(str)
+	var rows []interface{}
+	for r := 0; cols > 0 && r*cols < len(runes); r++ {
+		rows = append(rows, string(runes[r*cols:(r+1)*cols]))
+	}
+	return rows
+}
+
+// text returns the characters of arr as a string, if it is made up of
+// characters only.
+func text(arr *value.Arr) (string, bool) {
+	if arr.Len() == 0 {
+		return "", false
+	}
+
+	var b strings.Builder
+	for _, val := range arr.Items() {
+		char, ok := val.(*value.Char)
+		if !ok {
+			return "", false
+		}
+		b.WriteRune(char.Value)
+	}
+	return b.String(), true
+}
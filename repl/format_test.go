@@ -0,0 +1,59 @@
+package repl
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/minond/calc/value"
+)
+
+func TestFormat(t *testing.T) {
+	vec := func(vals ...value.Value) *value.Arr {
+		return value.NewArr(vals)
+	}
+	one, two, three, four := value.NewInt(1), value.NewInt(2), value.NewInt(3), value.NewInt(4)
+	matrix := &value.Arr{Shape: []int{2, 2}, Values: []value.Value{one, two, three, four}}
+	third := value.NewNum(new(big.Float).SetPrec(64).Quo(big.NewFloat(1), big.NewFloat(3)))
+
+	tests := []struct {
+		label  string
+		val    value.Value
+		format Format
+		output string
+	}{
+		{"plain number", third, Plain, "0.33333333333333333334"},
+		{"plain vector", vec(one, two), Plain, "1 2"},
+		{"plain matrix", matrix, Plain, "1 2\n  3 4"},
+		{"json number", third, JSON, "0.33333333333333333334"},
+		{"json vector", vec(one, two), JSON, "[1,2]"},
+		{"json string", value.NewStr("héllo"), JSON, `"héllo"`},
+		{"json nested", vec(value.NewStr("ab"), vec(one)), JSON, `["ab",[1]]`},
+		{"json matrix", matrix, JSON, `[[1,2],[3,4]]`},
+		{"json character matrix", &value.Arr{Shape: []int{2, 2}, Values: value.NewStr("abcd").Values}, JSON, `["ab","cd"]`},
+		{"json scalar", &value.Arr{Shape: []int{}, Values: []value.Value{one}}, JSON, `1`},
+		{"json rational", &value.Num{Value: big.NewFloat(0.5), Rat: big.NewRat(1, 2)}, JSON, `{"num":"1/2"}`},
+		{"tsv number", one, TSV, "1"},
+		{"tsv vector", vec(one, two, three), TSV, "1\t2\t3"},
+		{"tsv string", value.NewStr("a b"), TSV, "a b"},
+		{"tsv matrix", matrix, TSV, "1\t2\n3\t4"},
+		{"tsv empty vector", &value.Arr{Values: []value.Value{}}, TSV, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			out, err := format(value.NewEnvironment(), test.val, test.format)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if out != test.output {
+				t.Errorf("invalid output:\nexpected: %q\nreturned: %q", test.output, out)
+			}
+		})
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	fn := value.NewFn(1, "{⍵}", nil)
+	if _, err := format(value.NewEnvironment(), fn, JSON); err == nil || err.Error() != "cannot encode a function as JSON" {
+		t.Errorf("expecting an error encoding a function but got %v", err)
+	}
+}
@@ -2,8 +2,10 @@ package repl
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"strings"
 
 	"github.com/minond/calc/parser"
@@ -17,6 +19,12 @@ type Repl struct {
 
 	parser *parser.Parser
 	env    *value.Environment
+	reader *bufio.Reader
+	format Format
+
+	// workspace is the file the workspace was last loaded from or saved
+	// to, which )save writes to when it is not given one.
+	workspace string
 
 	running   bool
 	debugging bool
@@ -30,6 +38,7 @@ func NewRepl(input io.Reader, output io.Writer) *Repl {
 		Output:    output,
 		parser:    parse,
 		env:       env,
+		reader:    bufio.NewReader(input),
 		running:   true,
 		debugging: false,
 	}
@@ -51,10 +60,32 @@ func (repl *Repl) SetDebug(debugging bool) {
 	repl.debugging = debugging
 }
 
+func (repl Repl) RightToLeft() bool {
+	return repl.env.RightToLeft()
+}
+
+func (repl *Repl) SetRightToLeft(rtl bool) {
+	repl.env.SetRightToLeft(rtl)
+}
+
+func (repl Repl) Format() Format {
+	return repl.format
+}
+
+// SetFormat sets how Run and Batch write results. The interactive session
+// always uses the plain format.
+func (repl *Repl) SetFormat(format Format) {
+	repl.format = format
+}
+
+// Read returns the next line of the input, without its surrounding space. A
+// last line that is not terminated by a newline is still returned, and
+// io.EOF only once the input is exhausted.
 func (repl Repl) Read() (string, error) {
-	reader := bufio.NewReader(repl.Input)
-	input, err := reader.ReadString('\n')
-	if err != nil {
+	input, err := repl.reader.ReadString('\n')
+	if err == io.EOF && input != "" {
+		err = nil
+	} else if err != nil {
 		return "", err
 	}
 	return strings.TrimSpace(input), nil
@@ -67,7 +98,10 @@ func (repl Repl) Write(s string, a ...interface{}) error {
 
 func (repl Repl) Eval(code string) {
 	expr, err := repl.parser.Parse(code)
-	if err != nil {
+	if serr, ok := err.(*parser.Error); ok {
+		repl.Write("syntax error: %v\n%s\n", serr, highlight(code, serr.Span))
+		return
+	} else if err != nil {
 		repl.Write("syntax error: %v\n\n", err)
 		return
 	} else if repl.debugging {
@@ -76,10 +110,284 @@ func (repl Repl) Eval(code string) {
 	}
 
 	val, err := evaluator.Eval(repl.env, expr)
-	if err != nil {
+	if eerr, ok := err.(*evaluator.Error); ok && eerr.Expr != nil {
+		repl.Write("error: %v\n%s\n", eerr, stacktrace(code, eerr))
+	} else if err != nil {
 		repl.Write("error: %v\n\n", err)
 	} else {
 		repl.env.SetVal("_", val)
-		repl.Write("= %s\n\n", val.Stringify())
+		repl.Write("= %s\n\n", value.Format(repl.env, val))
+	}
+}
+
+// IsCommand reports whether input is a system command, such as )save, rather
+// than code.
+func IsCommand(input string) bool {
+	return strings.HasPrefix(input, ")")
+}
+
+// Command runs a system command, which manages the workspace:
+//
+//	)save [file]  writes the bindings of the session to file, or to the file
+//	              the workspace was last loaded from or saved to
+//	)load file    replaces the bindings of the session with those in file
+//	)copy file    adds the bindings in file to those of the session
+//
+// It writes what the command did to the output.
+func (repl *Repl) Command(input string) error {
+	msg, err := repl.command(input)
+	if err != nil {
+		return err
+	}
+	return repl.Write("%s\n", msg)
+}
+
+// command runs a system command, returning a description of what it did.
+func (repl *Repl) command(input string) (string, error) {
+	args := strings.Fields(strings.TrimPrefix(input, ")"))
+	if len(args) == 0 {
+		return "", errors.New("missing command")
+	}
+
+	name, args := args[0], args[1:]
+	switch {
+	case name == "save" && len(args) <= 1:
+		file := repl.workspace
+		if len(args) == 1 {
+			file = args[0]
+		} else if file == "" {
+			return "", errors.New("missing workspace file")
+		}
+		if err := save(repl.env, file); err != nil {
+			return "", err
+		}
+		repl.workspace = file
+		return "saved " + file, nil
+
+	case name == "load" && len(args) == 1:
+		env := value.NewEnvironment()
+		env.SetRightToLeft(repl.env.RightToLeft())
+		if err := load(env, args[0]); err != nil {
+			return "", err
+		}
+		repl.env = env
+		repl.parser = parser.NewParser(env)
+		repl.workspace = args[0]
+		return "loaded " + args[0], nil
+
+	case name == "copy" && len(args) == 1:
+		if err := load(repl.env, args[0]); err != nil {
+			return "", err
+		}
+		return "copied " + args[0], nil
+
+	case name == "save" || name == "load" || name == "copy":
+		return "", fmt.Errorf("usage: )%s file", name)
+	}
+	return "", fmt.Errorf("unknown command )%s", name)
+}
+
+func save(env *value.Environment, file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := env.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func load(env *value.Environment, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return env.Load(f)
+}
+
+// Run evaluates a script, printing the result of every statement other than
+// assignments and definitions, and stops at the first statement that fails.
+// Errors are returned as *ScriptError values, which point at the part of the
+// script that failed.
+func (repl *Repl) Run(name, code string) error {
+	return repl.run(name, code, 0)
+}
+
+// Batch evaluates the input a line at a time, as Run does a script, without
+// prompts. A line that leaves parentheses or braces open is joined with the
+// lines that follow it until they are closed, as statements in a script
+// are. System commands are run without writing what they did, so that only
+// results reach the output. A failing line does not stop the lines after it
+// from running: errors are written to errs, and Batch reports whether every
+// line succeeded once the input is exhausted.
+func (repl *Repl) Batch(name string, errs io.Writer) bool {
+	ok := true
+	for line := 0; ; {
+		input, err := repl.Read()
+		if err == io.EOF {
+			return ok
+		} else if err != nil {
+			fmt.Fprintf(errs, "%s: error: %v\n", name, err)
+			return false
+		}
+
+		if IsCommand(input) {
+			// Only results are written to the output, which may be read by
+			// another program.
+			_, err = repl.command(input)
+			if err != nil {
+				fmt.Fprintf(errs, "%s:%d: error: %v\n", name, line+1, err)
+				ok = false
+			}
+			line++
+			continue
+		}
+
+		// An input that ends with a group still open is left to the parser
+		// to report.
+		lines := 1
+		for parser.Open(input) {
+			more, err := repl.Read()
+			if err != nil {
+				break
+			}
+			input += "\n" + more
+			lines++
+		}
+
+		if err := repl.run(name, input, line); err != nil {
+			fmt.Fprint(errs, err)
+			ok = false
+		}
+		line += lines
+	}
+}
+
+// run evaluates code that starts after the given number of lines of the
+// input named name.
+func (repl *Repl) run(name, code string, line int) error {
+	fail := func(err error) error {
+		return &ScriptError{Name: name, Code: code, Line: line, Err: err}
+	}
+
+	if err := repl.parser.Statements(code); err != nil {
+		return fail(err)
+	}
+
+	for {
+		expr, err := repl.parser.Next()
+		if err != nil {
+			return fail(err)
+		} else if expr == nil {
+			return nil
+		}
+
+		val, err := evaluator.Eval(repl.env, expr)
+		if err != nil {
+			return fail(err)
+		}
+		repl.env.SetVal("_", val)
+
+		switch e := expr.(type) {
+		case *parser.OpDef:
+			continue
+		case *parser.Op:
+			if e.Op == ":=" {
+				continue
+			}
+		}
+		out, err := format(repl.env, val, repl.format)
+		if err != nil {
+			return fail(err)
+		}
+		repl.Write("%s\n", out)
+	}
+}
+
+// ScriptError is a syntax or runtime error in a script named Name. Line is
+// the number of lines of the script that come before Code, for scripts that
+// are evaluated a line at a time.
+type ScriptError struct {
+	Name string
+	Code string
+	Line int
+	Err  error
+}
+
+// Error renders the error as the position it happened at followed by the
+// line of the script it is on, as an editor would expect to find it.
+func (e *ScriptError) Error() string {
+	switch err := e.Err.(type) {
+	case *parser.Error:
+		return fmt.Sprintf("%s:%s: syntax error: %s\n%s", e.Name, e.position(err.Span), err.Msg, highlight(e.Code, err.Span))
+	case *evaluator.Error:
+		if err.Expr != nil {
+			return fmt.Sprintf("%s:%s: error: %v\n%s", e.Name, e.position(err.Expr.Span()), err, stacktrace(e.Code, err))
+		}
+	}
+	return fmt.Sprintf("%s: error: %v\n", e.Name, e.Err)
+}
+
+// position is the line and column that span starts at in the script.
+func (e *ScriptError) position(span parser.Span) string {
+	return fmt.Sprintf("%d:%d", e.Line+span.Start.Line, span.Start.Col)
+}
+
+// highlight returns the line of code that span starts on with a row of
+// carets underneath the part of it that span covers.
+func highlight(code string, span parser.Span) string {
+	lines := strings.Split(code, "\n")
+	if span.Start.Line < 1 || span.Start.Line > len(lines) {
+		return ""
+	}
+
+	line := []rune(lines[span.Start.Line-1])
+	start := span.Start.Col - 1
+	end := len(line)
+	if span.End.Line == span.Start.Line {
+		end = span.End.Col - 1
+	}
+	if end <= start {
+		end = start + 1
+	}
+
+	var marker strings.Builder
+	for i := 0; i < start; i++ {
+		if i < len(line) && line[i] == '\t' {
+			marker.WriteRune('\t')
+		} else {
+			marker.WriteRune(' ')
+		}
+	}
+	marker.WriteString(strings.Repeat("^", end-start))
+
+	return fmt.Sprintf("  %s\n  %s\n", string(line), marker.String())
+}
+
+// stacktrace renders a runtime error as the failing call, the source line
+// with the failing sub-expression underlined, and then each enclosing
+// application from the innermost outwards.
+func stacktrace(code string, err *evaluator.Error) string {
+	var buff strings.Builder
+	if err.Op != "" {
+		fmt.Fprintf(&buff, "  calling %s with %s\n", err.Op, strings.Join(err.Types, ", "))
+	}
+	buff.WriteString(highlight(code, err.Expr.Span()))
+	for _, expr := range err.Trace {
+		fmt.Fprintf(&buff, "  in %s at %s\n", excerpt(code, expr.Span()), expr.Span())
+	}
+	return buff.String()
+}
+
+// excerpt returns the part of code that span covers.
+func excerpt(code string, span parser.Span) string {
+	runes := []rune(code)
+	start, end := span.Start.Offset, span.End.Offset
+	if start < 0 || end > len(runes) || start > end {
+		return ""
 	}
+	return string(runes[start:end])
 }
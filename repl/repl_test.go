@@ -0,0 +1,152 @@
+package repl
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		label  string
+		code   string
+		format Format
+		output string
+		err    string
+	}{
+		{"empty script", "", Plain, "", ""},
+		{"result", "1 + 2", Plain, "3\n", ""},
+		{"assignments are not printed", "x := 2\nx × 3", Plain, "6\n", ""},
+		{"definitions are not printed", "double := {⍵ × 2}\ndouble 4", Plain, "8\n", ""},
+		{"statements on a line", "1 ⋄ 2", Plain, "1\n2\n", ""},
+		{"comments", "# nothing\n1", Plain, "1\n", ""},
+		{"statement over lines", "(1\n+ 2)", Plain, "3\n", ""},
+		{"operators defined earlier split names", "a %% b := a + b\nx := 1\nx%%x", Plain, "2\n", ""},
+		{"json", "1 2.5 3", JSON, "[1,2.5,3]\n", ""},
+		{"json matrix", "2 2 ⍴ 1 .. 5", JSON, "[[1,2],[3,4]]\n", ""},
+		{"tsv", "1 2 3", TSV, "1\t2\t3\n", ""},
+		{"stops at a runtime error", "1\n2 ÷ 0\n3", Plain, "1\n",
+			"test:2:1: error: division by zero\n  calling ÷ with <number>, <number>\n  2 ÷ 0\n  ^^^^^\n"},
+		{"stops at a syntax error", "1\n2 +\n3", Plain, "1\n",
+			"test:2:4: syntax error: unexpected end of statement\n  2 +\n     ^\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var out bytes.Buffer
+			repl := NewRepl(strings.NewReader(""), &out)
+			repl.SetFormat(test.format)
+
+			var msg string
+			if err := repl.Run("test", test.code); err != nil {
+				msg = err.Error()
+			}
+			if msg != test.err {
+				t.Errorf("invalid error:\nexpected: %q\nreturned: %q", test.err, msg)
+			}
+			if out.String() != test.output {
+				t.Errorf("invalid output:\nexpected: %q\nreturned: %q", test.output, out.String())
+			}
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ws := filepath.Join(dir, "ws.json")
+
+	tests := []struct {
+		label  string
+		input  string
+		format Format
+		output string
+		errs   string
+		ok     bool
+	}{
+		{"empty input", "", Plain, "", "", true},
+		{"line at a time", "1 + 2\nx := 3\nx × 2\n", Plain, "3\n6\n", "", true},
+		{"last line without a newline", "1\n2", Plain, "1\n2\n", "", true},
+		{"blank lines", "1\n\n2\n", Plain, "1\n2\n", "", true},
+		{"failing lines do not stop the rest", "1 ÷ 0\n2\n", Plain, "2\n",
+			"stdin:1:1: error: division by zero\n  calling ÷ with <number>, <number>\n  1 ÷ 0\n  ^^^^^\n", false},
+		{"group over lines", "(1\n+ 2)\n3\n", Plain, "3\n3\n", "", true},
+		{"definition over lines", "f := {\n⍵ × 2\n}\nf 4\n", Plain, "8\n", "", true},
+		{"lines are counted past groups", "(1\n+ 2)\n1 ÷ 0\n", Plain, "3\n",
+			"stdin:3:1: error: division by zero\n  calling ÷ with <number>, <number>\n  1 ÷ 0\n  ^^^^^\n", false},
+		{"group left open", "(1 +\n", Plain, "",
+			"stdin:1:5: syntax error: unexpected eof\n  (1 +\n      ^\n", false},
+		{"unknown command", ")nope\n1\n", Plain, "1\n", "stdin:1: error: unknown command )nope\n", false},
+		{"json", "1 2\n'ab'\n", JSON, "[1,2]\n\"ab\"\n", "", true},
+		{"tsv", "1 2\n3\n", TSV, "1\t2\n3\n", "", true},
+		{"workspace commands write nothing", "s := '12'\n)save " + ws + "\n)load " + ws + "\n⍴ s\n", JSON, "[2]\n", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var out, errs bytes.Buffer
+			repl := NewRepl(strings.NewReader(test.input), &out)
+			repl.SetFormat(test.format)
+
+			if ok := repl.Batch("stdin", &errs); ok != test.ok {
+				t.Errorf("expecting Batch to report %v", test.ok)
+			}
+			if out.String() != test.output {
+				t.Errorf("invalid output:\nexpected: %q\nreturned: %q", test.output, out.String())
+			}
+			if errs.String() != test.errs {
+				t.Errorf("invalid errors:\nexpected: %q\nreturned: %q", test.errs, errs.String())
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	repl := NewRepl(strings.NewReader("  1 + 2 \n\n3"), &bytes.Buffer{})
+	for _, expected := range []string{"1 + 2", "", "3"} {
+		line, err := repl.Read()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if line != expected {
+			t.Errorf("invalid line:\nexpected: %q\nreturned: %q", expected, line)
+		}
+	}
+	if _, err := repl.Read(); err != io.EOF {
+		t.Errorf("expecting io.EOF but got %v", err)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		err    string
+	}{
+		{"plain", Plain, ""},
+		{"json", JSON, ""},
+		{"tsv", TSV, ""},
+		{"csv", Plain, `unknown format "csv", expecting plain, json or tsv`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			format, err := ParseFormat(test.name)
+			var msg string
+			if err != nil {
+				msg = err.Error()
+			}
+			if msg != test.err {
+				t.Errorf("invalid error:\nexpected: %q\nreturned: %q", test.err, msg)
+			} else if format != test.format {
+				t.Errorf("invalid format: expected %d but got %d", test.format, format)
+			}
+		})
+	}
+}
@@ -0,0 +1,713 @@
+package value
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// size returns the number of items in an array of the given shape.
+func size(dims []int) int {
+	n := 1
+	for _, d := range dims {
+		n *= d
+	}
+	return n
+}
+
+// maxItems is the most items of an array that is built from a count, such
+// as by compress, which checkItems checks before the array is allocated.
+const maxItems = 1 << 24
+
+// checkItems returns an error unless n copies of m items fit in an array.
+func checkItems(n, m int) error {
+	if n < 0 || m < 0 || m > 0 && n > maxItems/m {
+		return fmt.Errorf("result is too large, with more than %d items", maxItems)
+	}
+	return nil
+}
+
+func sameDims(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func dimsString(dims []int) string {
+	strs := make([]string, len(dims))
+	for i, d := range dims {
+		strs[i] = fmt.Sprint(d)
+	}
+	return strings.Join(strs, " ")
+}
+
+// shaped creates an array of the given shape, using a plain vector when the
+// shape has a single axis.
+func shaped(dims []int, vals []Value) *Arr {
+	if len(dims) == 1 {
+		return pack(&Arr{Values: vals})
+	}
+	return pack(&Arr{Shape: dims, Values: vals})
+}
+
+// unboxed collects machine numbers into the storage of an array, as ints
+// until the first float comes along and as floats from then on.
+type unboxed struct {
+	ints   []int64
+	floats []float64
+}
+
+func newUnboxed(n int) *unboxed {
+	return &unboxed{ints: make([]int64, 0, n)}
+}
+
+// add appends n, reporting false when it is not a machine number or when
+// the integers read so far cannot all be held as floats.
+func (u *unboxed) add(n *Num) bool {
+	if n.kind == kindInt && u.floats == nil {
+		u.ints = append(u.ints, n.i)
+		return true
+	} else if n.kind != kindInt && n.kind != kindFloat {
+		return false
+	}
+
+	if u.floats == nil {
+		u.floats = make([]float64, len(u.ints), cap(u.ints))
+		for i, item := range u.ints {
+			f, ok := intFloat64(item)
+			if !ok {
+				return false
+			}
+			u.floats[i] = f
+		}
+		u.ints = nil
+	}
+	f, ok := n.float64()
+	if !ok {
+		return false
+	}
+	u.floats = append(u.floats, f)
+	return true
+}
+
+func (u *unboxed) arr(shape []int) *Arr {
+	return &Arr{Shape: shape, ints: u.ints, floats: u.floats}
+}
+
+// pack stores the items of arr unboxed when they are all machine numbers.
+// Floats that hold an integer are read back as integers, which is how
+// newFloat64 stores them anyway.
+func pack(arr *Arr) *Arr {
+	if len(arr.Values) == 0 {
+		return arr
+	}
+	u := newUnboxed(len(arr.Values))
+	for _, val := range arr.Values {
+		num, ok := val.(*Num)
+		if !ok || !u.add(num) {
+			return arr
+		}
+	}
+	return u.arr(arr.Shape)
+}
+
+// intsArr creates a vector of integers.
+func intsArr(ints []int64) *Arr {
+	if len(ints) == 0 {
+		return &Arr{Values: []Value{}}
+	}
+	return &Arr{ints: ints}
+}
+
+// section returns the items of arr from offset i to j as an array of the
+// given shape, which shares the storage of arr.
+func section(arr *Arr, dims []int, i, j int) *Arr {
+	res := &Arr{Shape: dims}
+	if len(dims) == 1 {
+		res.Shape = nil
+	}
+	switch {
+	case i == j:
+		res.Values = []Value{}
+	case arr.ints != nil:
+		res.ints = arr.ints[i:j]
+	case arr.floats != nil:
+		res.floats = arr.floats[i:j]
+	default:
+		res.Values = arr.Values[i:j]
+	}
+	return res
+}
+
+// machineItems returns the items of val when it is a machine number or an
+// array that holds them unboxed, as either ints or floats.
+func machineItems(val Value) ([]int64, []float64, bool) {
+	switch v := val.(type) {
+	case *Num:
+		switch v.kind {
+		case kindInt:
+			return []int64{v.i}, nil, true
+		case kindFloat:
+			return nil, []float64{v.f}, true
+		}
+	case *Arr:
+		return v.ints, v.floats, v.ints != nil || v.floats != nil
+	}
+	return nil, nil, false
+}
+
+// machineAt is item i of the storage returned by machineItems.
+func machineAt(ints []int64, floats []float64, i int) Num {
+	if ints != nil {
+		return Num{kind: kindInt, i: ints[i]}
+	}
+	return float64Num(floats[i])
+}
+
+// numbers are the items of a number or of an array of numbers, which are
+// read without boxing them when the array holds machine numbers.
+type numbers struct {
+	ints   []int64
+	floats []float64
+	vals   []Value
+}
+
+func numbersOf(val Value) (numbers, bool) {
+	if ints, floats, ok := machineItems(val); ok {
+		return numbers{ints: ints, floats: floats}, true
+	}
+	switch v := val.(type) {
+	case *Num:
+		return numbers{vals: []Value{v}}, true
+	case *Arr:
+		return numbers{vals: v.Values}, v.Values != nil
+	}
+	return numbers{}, false
+}
+
+func (ns numbers) len() int {
+	return len(ns.ints) + len(ns.floats) + len(ns.vals)
+}
+
+// at returns item i, which is stored in scratch when it is a machine
+// number, or false when it is not a number.
+func (ns numbers) at(i int, scratch *Num) (*Num, bool) {
+	if ns.vals == nil {
+		*scratch = machineAt(ns.ints, ns.floats, i)
+		return scratch, true
+	}
+	num, ok := ns.vals[i].(*Num)
+	return num, ok
+}
+
+// vector combines two arrays of numbers of the same shape, or one such
+// array and a number, without going through pervade. Results are stored
+// unboxed for as long as they are machine numbers, and boxed from the first
+// one that is not, which is computed by reals when it can be and by
+// operation otherwise. It reports false when either side has an item that
+// is not a number, for pervade to deal with instead.
+func (op *arith) vector(env *Environment, lhs, rhs Value, operation func(*Environment, *Num, *Num) (*Num, error)) (*Arr, bool, error) {
+	la, laok := lhs.(*Arr)
+	ra, raok := rhs.(*Arr)
+	shape := []int(nil)
+	switch {
+	case laok && raok && !sameDims(la.Dims(), ra.Dims()):
+		return nil, false, nil
+	case laok:
+		shape = la.Shape
+	case raok:
+		shape = ra.Shape
+	}
+
+	lnums, lok := numbersOf(lhs)
+	rnums, rok := numbersOf(rhs)
+	if !lok || !rok {
+		return nil, false, nil
+	}
+
+	// A single number is paired with every item of the other side.
+	ln, rn := lnums.len(), rnums.len()
+	n := ln
+	if rn > n {
+		n = rn
+	}
+	// The scratch numbers are shared by every pair, so that they escape
+	// only once.
+	var xs, ys Num
+	var x, y *Num
+	pair := func(i int) bool {
+		l, r := 0, 0
+		if ln > 1 {
+			l = i
+		}
+		if rn > 1 {
+			r = i
+		}
+		var xok, yok bool
+		x, xok = lnums.at(l, &xs)
+		y, yok = rnums.at(r, &ys)
+		return xok && yok
+	}
+
+	mode := modeOf(env)
+	u := newUnboxed(n)
+	i := 0
+	for ; i < n; i++ {
+		if !pair(i) || !machinePair(x, y) {
+			break
+		}
+		res, ok := op.apply(mode, x, y)
+		if !ok || !u.add(&res) {
+			break
+		}
+	}
+	if i == n {
+		return u.arr(shape), true, nil
+	}
+
+	// The results so far are machine numbers, which are boxed again rather
+	// than read back from u, since the add that failed may have left it
+	// half converted to floats.
+	res := &Arr{Shape: shape, Values: make([]Value, n)}
+	reals := realsOf(env, op, n-i)
+	for j := 0; j < n; j++ {
+		if !pair(j) {
+			return nil, false, nil
+		}
+		if machinePair(x, y) {
+			if item, ok := op.apply(mode, x, y); j < i || ok {
+				boxed := item
+				res.Values[j] = &boxed
+				continue
+			}
+		}
+
+		val := reals(x, y)
+		if val == nil {
+			var err error
+			if val, err = operation(env, x, y); err != nil {
+				return nil, false, err
+			}
+		}
+		if val == &xs || val == &ys {
+			boxed := *val
+			val = &boxed
+		}
+		res.Values[j] = val
+	}
+	return pack(res), true, nil
+}
+
+func machinePair(x, y *Num) bool {
+	return (x.kind == kindInt || x.kind == kindFloat) && (y.kind == kindInt || y.kind == kindFloat)
+}
+
+// realsOf returns a function that computes op with reals on a pair of
+// numbers, at the precision and rounding mode of env, when the operation
+// would. That is when neither of them is complex or rational, and either of
+// them is a float that is not kept exact. It returns nil otherwise. Results
+// are allocated n at a time.
+func realsOf(env *Environment, op *arith, n int) func(x, y *Num) *Num {
+	prec, mode := env.Precision(), env.RoundingMode()
+	var a, b big.Float
+	a.SetPrec(int64Prec)
+	b.SetPrec(int64Prec)
+
+	type result struct {
+		num Num
+		val big.Float
+	}
+	results := []result(nil)
+
+	real := func(n *Num) bool {
+		return n.Imag == nil && n.Rat == nil
+	}
+	inexact := func(n *Num) bool {
+		return n.kind == kindFloat || n.kind == kindBig
+	}
+	return func(x, y *Num) *Num {
+		if op.reals == nil || !real(x) || !real(y) || !inexact(x) && !inexact(y) {
+			return nil
+		}
+		if len(results) == 0 {
+			results = make([]result, n)
+		}
+		res := &results[0]
+		results = results[1:]
+		z := op.reals(res.val.SetPrec(prec).SetMode(mode), x.realIn(&a), y.realIn(&b))
+		if z == nil {
+			return nil
+		}
+		res.num.setFloat(z)
+		return &res.num
+	}
+}
+
+// reduce folds op along the last axis of an array of machine numbers
+// without boxing them, from the left when left is set and from the right
+// otherwise. It reports false when arr is not such an array, or when any
+// result is not a machine number, for the items to be folded one by one.
+func (op *arith) reduce(env *Environment, arr *Arr, left bool) (Value, bool) {
+	ints, floats, ok := machineItems(arr)
+	dims := arr.Dims()
+	if !ok || len(dims) == 0 || dims[len(dims)-1] == 0 {
+		return nil, false
+	}
+
+	n := dims[len(dims)-1]
+	cells := size(dims[:len(dims)-1])
+	mode := modeOf(env)
+	u := newUnboxed(cells)
+	var acc Num
+	for c := 0; c < cells; c++ {
+		if left {
+			acc = machineAt(ints, floats, c*n)
+			for i := 1; i < n && ok; i++ {
+				item := machineAt(ints, floats, c*n+i)
+				acc, ok = op.apply(mode, &acc, &item)
+			}
+		} else {
+			acc = machineAt(ints, floats, c*n+n-1)
+			for i := n - 2; i >= 0 && ok; i-- {
+				item := machineAt(ints, floats, c*n+i)
+				acc, ok = op.apply(mode, &item, &acc)
+			}
+		}
+		if !ok || !u.add(&acc) {
+			return nil, false
+		}
+	}
+
+	if len(dims) == 1 {
+		res := acc
+		return &res, true
+	}
+	res := u.arr(dims[:len(dims)-1])
+	if len(dims) == 2 {
+		res.Shape = nil
+	}
+	return res, true
+}
+
+// pervade applies operation to every pair of numbers in lhs and rhs, going
+// into nested arrays. Arrays of the same shape are paired item by item, a
+// number or a single item array is paired with every item of the other side,
+// and an array whose shape matches the trailing axes of the other is repeated
+// along the leading ones, so that a vector can be added to every row of a
+// matrix.
+func pervade(lhs, rhs Value, operation func(*Num, *Num) (*Num, error)) (Value, error) {
+	la, lok := lhs.(*Arr)
+	ra, rok := rhs.(*Arr)
+
+	switch {
+	case !lok && !rok:
+		ln, lok := lhs.(*Num)
+		rn, rok := rhs.(*Num)
+		if !lok || !rok {
+			return nil, fmt.Errorf("expecting numbers but got %s and %s", Ty(lhs), Ty(rhs))
+		}
+		return operation(ln, rn)
+
+	case lok && !rok:
+		return mapArr(la, func(item Value) (Value, error) {
+			return pervade(item, rhs, operation)
+		})
+
+	case !lok && rok:
+		return mapArr(ra, func(item Value) (Value, error) {
+			return pervade(lhs, item, operation)
+		})
+	}
+
+	ldims, rdims := la.Dims(), ra.Dims()
+	switch {
+	case la.Len() == 1 && ra.Len() != 1:
+		return pervade(la.At(0), ra, operation)
+	case ra.Len() == 1 && la.Len() != 1:
+		return pervade(la, ra.At(0), operation)
+	}
+
+	dims := ldims
+	if len(rdims) > len(ldims) {
+		dims = rdims
+	}
+
+	if !sameDims(ldims, dims[len(dims)-len(ldims):]) || !sameDims(rdims, dims[len(dims)-len(rdims):]) {
+		if len(ldims) == 1 && len(rdims) == 1 {
+			return nil, fmt.Errorf("array sizes do not match, left has %d items but right has %d",
+				la.Len(), ra.Len())
+		}
+		return nil, fmt.Errorf("array shapes do not match, left is %s but right is %s",
+			dimsString(ldims), dimsString(rdims))
+	}
+
+	res := make([]Value, size(dims))
+	for i := range res {
+		item, err := pervade(la.At(i%la.Len()), ra.At(i%ra.Len()), operation)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = item
+	}
+	return shaped(dims, res), nil
+}
+
+// mapArr applies fn to every item of arr, keeping its shape.
+func mapArr(arr *Arr, fn func(Value) (Value, error)) (*Arr, error) {
+	res := &Arr{Shape: arr.Shape, Values: make([]Value, arr.Len())}
+	for i := range res.Values {
+		val, err := fn(arr.At(i))
+		if err != nil {
+			return nil, err
+		}
+		res.Values[i] = val
+	}
+	return pack(res), nil
+}
+
+// ravel returns the items of val as a vector.
+func ravel(val Value) *Arr {
+	if arr, ok := val.(*Arr); ok {
+		return section(arr, []int{arr.Len()}, 0, arr.Len())
+	}
+	return &Arr{Values: []Value{val}}
+}
+
+// lastAxis splits arr into the vectors that run along its last axis. The
+// returned shape is what is left of arr's shape once that axis is removed.
+func lastAxis(arr *Arr) ([][]Value, []int) {
+	dims := arr.Dims()
+	items := arr.Items()
+	if len(dims) == 0 {
+		return [][]Value{items}, nil
+	}
+
+	n := dims[len(dims)-1]
+	cells := make([][]Value, size(dims[:len(dims)-1]))
+	for i := range cells {
+		cells[i] = items[i*n : (i+1)*n]
+	}
+	return cells, dims[:len(dims)-1]
+}
+
+// dimensions converts a number or vector of numbers into a shape.
+func dimensions(val Value) ([]int, error) {
+	var dims []int
+	for _, item := range ravel(val).Items() {
+		num, ok := item.(*Num)
+		if !ok {
+			return nil, fmt.Errorf("expecting a shape of numbers but got %s", Ty(item))
+		}
+		n, err := count(num)
+		if err != nil {
+			return nil, err
+		}
+		dims = append(dims, n)
+	}
+	return dims, nil
+}
+
+// shape is APL's monadic `⍴`, the length of every axis of its argument.
+var shape = &Fn{
+	Argc: 1,
+	Impl: fntable{
+		sig(TNum): func(env *Environment, vals ...Value) (Value, error) {
+			return &Arr{Values: []Value{}}, nil
+		},
+		sig(TChar): func(env *Environment, vals ...Value) (Value, error) {
+			return &Arr{Values: []Value{}}, nil
+		},
+		sig(TArr): func(env *Environment, vals ...Value) (Value, error) {
+			dims := vals[0].(*Arr).Dims()
+			res := make([]int64, len(dims))
+			for i, d := range dims {
+				res[i] = int64(d)
+			}
+			return intsArr(res), nil
+		},
+	},
+}
+
+// reshape is APL's dyadic `⍴`, which lays the items of its right argument
+// out in the shape given by its left one, repeating them as needed.
+func reshape(env *Environment, vals ...Value) (Value, error) {
+	dims, err := dimensions(vals[0])
+	if err != nil {
+		return nil, err
+	}
+
+	items := ravel(vals[1]).Items()
+	n := size(dims)
+	if n > 0 && len(items) == 0 {
+		return nil, errors.New("cannot reshape an empty array")
+	}
+
+	res := make([]Value, n)
+	for i := range res {
+		res[i] = items[i%len(items)]
+	}
+	if len(dims) == 0 {
+		return res[0], nil
+	}
+	return shaped(dims, res), nil
+}
+
+var reshape_ = &Op{
+	Prec: PrecCatenate,
+	Impl: fntable{
+		sig(TNum, TNum):  reshape,
+		sig(TNum, TArr):  reshape,
+		sig(TArr, TNum):  reshape,
+		sig(TArr, TArr):  reshape,
+		sig(TNum, TChar): reshape,
+		sig(TArr, TChar): reshape,
+	},
+}
+
+// ravel_ is APL's monadic `,`, which turns any array into a vector.
+var ravel_ = &Fn{
+	Argc: 1,
+	Impl: fntable{
+		sig(TNum): func(env *Environment, vals ...Value) (Value, error) {
+			return ravel(vals[0]), nil
+		},
+		sig(TArr): func(env *Environment, vals ...Value) (Value, error) {
+			return ravel(vals[0]), nil
+		},
+		sig(TChar): func(env *Environment, vals ...Value) (Value, error) {
+			return ravel(vals[0]), nil
+		},
+	},
+}
+
+// catenate is APL's dyadic `,`. Numbers and vectors are joined into a
+// single vector, and arrays of a higher rank are joined along their last
+// axis, after extending the other side to the same rank: a number is
+// repeated along every other axis, and an array of one rank less is taken to
+// have a last axis of length 1, so that `m , 9` adds 9 to the end of every
+// row of m. Joining anything with a generator gives a generator.
+func catenate(env *Environment, vals ...Value) (Value, error) {
+	la, lok := vals[0].(*Arr)
+	ra, rok := vals[1].(*Arr)
+	if (!lok || la.Rank() <= 1) && (!rok || ra.Rank() <= 1) {
+		items := append(append([]Value{}, ravel(vals[0]).Items()...), ravel(vals[1]).Items()...)
+		return NewArr(items), nil
+	}
+
+	if !lok || rok && ra.Rank() > la.Rank() {
+		la = extend(vals[0], ra.Dims())
+	} else {
+		ra = extend(vals[1], la.Dims())
+	}
+
+	ldims, rdims := la.Dims(), ra.Dims()
+	if len(ldims) != len(rdims) || !sameDims(ldims[:len(ldims)-1], rdims[:len(rdims)-1]) {
+		return nil, fmt.Errorf("array shapes do not match, left is %s but right is %s",
+			dimsString(dimsOf(vals[0])), dimsString(dimsOf(vals[1])))
+	}
+
+	lcells, _ := lastAxis(la)
+	rcells, _ := lastAxis(ra)
+	items := make([]Value, 0, la.Len()+ra.Len())
+	for i := range lcells {
+		items = append(items, lcells[i]...)
+		items = append(items, rcells[i]...)
+	}
+
+	dims := append([]int{}, ldims...)
+	dims[len(dims)-1] += rdims[len(rdims)-1]
+	return shaped(dims, items), nil
+}
+
+// extend gives val the rank of an array of the given dims, for catenate. A
+// number, or an array of a single item, is repeated to fill every axis but
+// the last, which has a length of 1, and an array of one rank less is given
+// a last axis of length 1. Anything else is left as it is.
+func extend(val Value, dims []int) *Arr {
+	arr, ok := val.(*Arr)
+	switch {
+	case !ok || arr.Rank() == 0 || arr.Len() == 1 && arr.Rank() < len(dims):
+		shape := append(append([]int{}, dims[:len(dims)-1]...), 1)
+		items := make([]Value, size(shape))
+		for i := range items {
+			items[i] = ravel(val).At(0)
+		}
+		return shaped(shape, items)
+	case arr.Rank() == len(dims)-1:
+		return section(arr, append(append([]int{}, arr.Dims()...), 1), 0, arr.Len())
+	}
+	return arr
+}
+
+// dimsOf returns the length of every axis of val, which has none when it is
+// not an array.
+func dimsOf(val Value) []int {
+	if arr, ok := val.(*Arr); ok {
+		return arr.Dims()
+	}
+	return nil
+}
+
+var catenate_ = &Op{
+	Prec: PrecCatenate,
+	Impl: fntable{
+		sig(TNum, TNum):   catenate,
+		sig(TNum, TArr):   catenate,
+		sig(TArr, TNum):   catenate,
+		sig(TArr, TArr):   catenate,
+		sig(TChar, TChar): catenate,
+		sig(TChar, TNum):  catenate,
+		sig(TChar, TArr):  catenate,
+		sig(TNum, TChar):  catenate,
+		sig(TArr, TChar):  catenate,
+		sig(TGen, TGen):   concatenate,
+		sig(TGen, TNum):   concatenate,
+		sig(TGen, TArr):   concatenate,
+		sig(TNum, TGen):   concatenate,
+		sig(TArr, TGen):   concatenate,
+	},
+}
+
+// enclose is APL's `⊂`, which wraps an array into a scalar so that it can be
+// an item of another array.
+var enclose = &Fn{
+	Argc: 1,
+	Impl: fntable{
+		sig(TNum): func(env *Environment, vals ...Value) (Value, error) {
+			return vals[0], nil
+		},
+		sig(TChar): func(env *Environment, vals ...Value) (Value, error) {
+			return vals[0], nil
+		},
+		sig(TArr): func(env *Environment, vals ...Value) (Value, error) {
+			return &Arr{Shape: []int{}, Values: []Value{vals[0]}}, nil
+		},
+	},
+}
+
+// first is APL's `⊃`, the first item of an array, which undoes `⊂`.
+var first = &Fn{
+	Argc: 1,
+	Impl: fntable{
+		sig(TNum): func(env *Environment, vals ...Value) (Value, error) {
+			return vals[0], nil
+		},
+		sig(TChar): func(env *Environment, vals ...Value) (Value, error) {
+			return vals[0], nil
+		},
+		sig(TArr): func(env *Environment, vals ...Value) (Value, error) {
+			arr := vals[0].(*Arr)
+			if arr.Len() == 0 {
+				return nil, errors.New("cannot take the first item of an empty array")
+			}
+			return arr.At(0), nil
+		},
+	},
+}
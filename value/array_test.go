@@ -0,0 +1,83 @@
+package value
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestUnboxedArrays(t *testing.T) {
+	vec := func(vals ...Value) *Arr {
+		return &Arr{Values: vals}
+	}
+	reduce := func(fn Callable) handler {
+		return func(env *Environment, vals ...Value) (Value, error) {
+			return Reduce(env, fn, vals[0])
+		}
+	}
+	max := NewInt(9223372036854775807)
+	half := NewNum(new(big.Float).SetPrec(64).SetFloat64(0.5))
+
+	tests := []struct {
+		label   string
+		prec    int64
+		fn      handler
+		args    []Value
+		out     string
+		unboxed bool
+	}{
+		{"add vectors", 0, add.Dispatch, []Value{vec(NewInt(1), NewInt(2)), vec(NewInt(3), NewInt(4))}, "4 6", true},
+		{"add a number", 0, add.Dispatch, []Value{NewInt(10), vec(NewInt(1), NewInt(2))}, "11 12", true},
+		{"add matrices", 0, add.Dispatch, []Value{&Arr{Shape: []int{2, 1}, Values: []Value{NewInt(1), NewInt(2)}}, &Arr{Shape: []int{2, 1}, Values: []Value{NewInt(3), NewInt(4)}}}, "4\n  6", true},
+		{"floats at a float64's precision", 53, mul.Dispatch, []Value{vec(NewInt(1), NewInt(3)), newFloat64(1.5)}, "1.5 4.5", true},
+		{"floats at a higher precision", 0, mul.Dispatch, []Value{vec(NewInt(1), NewInt(3)), newFloat64(1.5)}, "1.5 4.5", false},
+		{"floats and big floats", 0, mul.Dispatch, []Value{vec(NewInt(1), half, NewInt(3)), newFloat64(1.5)}, " 1.5 0.75  4.5", false},
+		{"overflow", 0, add.Dispatch, []Value{vec(max, NewInt(1)), NewInt(1)}, "9223372036854775808                   2", false},
+		{"division that is not exact", 0, div.Dispatch, []Value{vec(NewInt(4), NewInt(3)), NewInt(2)}, "  2 1.5", false},
+		{"division by zero", 0, div.Dispatch, []Value{vec(NewInt(0), NewInt(1)), NewInt(0)}, "division by zero", false},
+		{"residue of zero", 0, residue.Dispatch, []Value{vec(NewInt(0), NewInt(3)), NewInt(7)}, "7 1", true},
+		{"residue of floats by zero", 0, residue.Dispatch, []Value{NewInt(0), vec(newFloat64(1.5), newFloat64(2.5))}, "1.5 2.5", true},
+		{"nested arrays", 0, add.Dispatch, []Value{vec(NewInt(1), vec(NewInt(2))), NewInt(1)}, "2 (3)", false},
+		{"different shapes", 0, add.Dispatch, []Value{vec(NewInt(1), NewInt(2)), vec(NewInt(1))}, "2 3", true},
+		{"reduce", 0, reduce(add), []Value{vec(NewInt(1), NewInt(2), NewInt(3))}, "6", false},
+		{"reduce from the right", 0, reduce(sub), []Value{vec(NewInt(1), NewInt(2), NewInt(3))}, "2", false},
+		{"reduce rows", 0, reduce(sub), []Value{&Arr{Shape: []int{2, 2}, Values: []Value{NewInt(1), NewInt(2), NewInt(3), NewInt(5)}}}, "-1 -2", true},
+		{"reduce past an int64", 0, reduce(add), []Value{vec(max, max)}, "18446744073709551614", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			for _, packed := range []bool{false, true} {
+				env := NewEnvironment()
+				if test.prec != 0 {
+					if err := env.SetSys("⎕FPREC", NewInt(test.prec)); err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+				}
+
+				args := make([]Value, len(test.args))
+				for i, arg := range test.args {
+					if arr, ok := arg.(*Arr); ok && packed {
+						arg = pack(arr)
+					}
+					args[i] = arg
+				}
+
+				var out string
+				res, err := test.fn(env, args...)
+				if err != nil {
+					out = err.Error()
+				} else {
+					out = res.Stringify()
+				}
+				if out != test.out {
+					t.Errorf("invalid result with packed=%v:\nexpected: %s\nreturned: %s", packed, test.out, out)
+				}
+
+				arr, ok := res.(*Arr)
+				if unboxed := err == nil && ok && arr.Values == nil; packed && unboxed != test.unboxed {
+					t.Errorf("expecting the result to be unboxed=%v", test.unboxed)
+				}
+			}
+		})
+	}
+}
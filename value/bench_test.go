@@ -0,0 +1,94 @@
+package value
+
+import (
+	"testing"
+)
+
+func benchVector(b *testing.B, env *Environment, n float64) Value {
+	b.Helper()
+	val, err := until.Dispatch(env, num(n))
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+	return val
+}
+
+func BenchmarkReduceGen(b *testing.B) {
+	env := NewEnvironment()
+	gen, err := g_until.Dispatch(env, num(100000))
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Reduce(env, add, gen); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkReduceArr(b *testing.B) {
+	env := NewEnvironment()
+	arr := benchVector(b, env, 100000)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Reduce(env, add, arr); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkAddArrs(b *testing.B) {
+	env := NewEnvironment()
+	arr := benchVector(b, env, 100000)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := add.Dispatch(env, arr, arr); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+// BenchmarkMulFloats runs at the default precision of 64 bits, at which
+// floats that are not integers are multiplied as big.Float values.
+func BenchmarkMulFloats(b *testing.B) {
+	benchMulFloats(b, NewEnvironment())
+}
+
+// BenchmarkMulFloats53 runs at the precision of a float64, which is the only
+// one that floats are multiplied as machine numbers at.
+func BenchmarkMulFloats53(b *testing.B) {
+	env := NewEnvironment()
+	if err := env.SetSys("⎕FPREC", NewInt(float64Prec)); err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+	benchMulFloats(b, env)
+}
+
+func benchMulFloats(b *testing.B, env *Environment) {
+	arr := benchVector(b, env, 100000)
+	half, err := div.Dispatch(env, arr, num(2))
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := mul.Dispatch(env, half, num(1.5)); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkUntil(b *testing.B) {
+	env := NewEnvironment()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchVector(b, env, 100000)
+	}
+}
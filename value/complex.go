@@ -0,0 +1,193 @@
+package value
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/cmplx"
+)
+
+// NewComplex creates the number re + im i. Numbers without an imaginary part
+// are real.
+func NewComplex(re, im *big.Float) *Num {
+	if im.Sign() == 0 {
+		return NewNum(re)
+	}
+	return &Num{Value: re, Imag: im}
+}
+
+func (n *Num) complex() bool {
+	return n.Imag != nil
+}
+
+// parts returns the real and imaginary parts of n.
+func (n *Num) parts() (*big.Float, *big.Float) {
+	if n.complex() {
+		return n.Value, n.Imag
+	}
+	return n.Float(), big.NewFloat(0)
+}
+
+// isInf reports whether either part of n is infinite.
+func (n *Num) isInf() bool {
+	re, im := n.parts()
+	return re.IsInf() || im.IsInf()
+}
+
+// complex128 approximates n, for the operations that are only defined on
+// machine complex numbers.
+func (n *Num) complex128() complex128 {
+	re, im := n.parts()
+	x, _ := re.Float64()
+	y, _ := im.Float64()
+	return complex(x, y)
+}
+
+// realOnly fails when any of nums is complex, for operations that need an
+// order, such as comparisons.
+func realOnly(nums ...*Num) error {
+	for _, num := range nums {
+		if num.complex() {
+			return fmt.Errorf("expecting a real number but got %s", num.Stringify())
+		}
+	}
+	return nil
+}
+
+func complexAdd(env *Environment, lhs, rhs *Num) *Num {
+	a, b := lhs.parts()
+	c, d := rhs.parts()
+	return NewComplex(env.float().Add(a, c), env.float().Add(b, d))
+}
+
+func complexSub(env *Environment, lhs, rhs *Num) *Num {
+	a, b := lhs.parts()
+	c, d := rhs.parts()
+	return NewComplex(env.float().Sub(a, c), env.float().Sub(b, d))
+}
+
+// complexMul is (a + bi)(c + di) = (ac - bd) + (ad + bc)i.
+func complexMul(env *Environment, lhs, rhs *Num) *Num {
+	a, b := lhs.parts()
+	c, d := rhs.parts()
+	re := env.float().Sub(env.float().Mul(a, c), env.float().Mul(b, d))
+	im := env.float().Add(env.float().Mul(a, d), env.float().Mul(b, c))
+	return NewComplex(re, im)
+}
+
+// complexQuo is (a + bi) / (c + di), which is multiplied by c - di above and
+// below to leave a real denominator of c² + d². rhs must not be zero.
+func complexQuo(env *Environment, lhs, rhs *Num) *Num {
+	a, b := lhs.parts()
+	c, d := rhs.parts()
+	den := env.float().Add(env.float().Mul(c, c), env.float().Mul(d, d))
+	re := env.float().Add(env.float().Mul(a, c), env.float().Mul(b, d))
+	im := env.float().Sub(env.float().Mul(b, c), env.float().Mul(a, d))
+	return NewComplex(re.Quo(re, den), im.Quo(im, den))
+}
+
+// complexPower raises base to exp. Integer exponents are computed by repeated
+// squaring at the precision of env, anything else goes through complex128.
+func complexPower(env *Environment, base, exp *Num) (res *Num, err error) {
+	if n, ok := exp.int64(); ok {
+		if n < 0 && base.sign() == 0 && !base.complex() {
+			return nil, errors.New("division by zero")
+		}
+
+		// Both parts of a product may overflow, and big.Float panics on the
+		// Inf - Inf that subtracting them gives.
+		defer func() {
+			if r := recover(); r != nil {
+				if _, ok := r.(big.ErrNaN); !ok {
+					panic(r)
+				}
+				res, err = nil, errors.New("result is out of range")
+			}
+		}()
+
+		res = NewInt(1)
+		sq := base
+		for k := n; k != 0; k /= 2 {
+			if k%2 != 0 {
+				res = complexMul(env, res, sq)
+			}
+			if k/2 != 0 {
+				sq = complexMul(env, sq, sq)
+			}
+			if res.isInf() || sq.isInf() {
+				return nil, errors.New("result is out of range")
+			}
+		}
+		if n < 0 {
+			res = complexQuo(env, NewInt(1), res)
+		}
+		return res, nil
+	}
+
+	z := cmplx.Pow(base.complex128(), exp.complex128())
+	if cmplx.IsInf(z) || cmplx.IsNaN(z) {
+		return nil, errors.New("result is out of range")
+	}
+	return NewComplex(env.float().SetFloat64(real(z)), env.float().SetFloat64(imag(z))), nil
+}
+
+// complexAbs is the magnitude of n, the square root of the sum of the squares
+// of its parts.
+func complexAbs(env *Environment, n *Num) *Num {
+	re, im := n.parts()
+	sq := env.float().Add(env.float().Mul(re, re), env.float().Mul(im, im))
+	return NewNum(env.float().Sqrt(sq))
+}
+
+// complexEqual reports whether both parts of lhs and rhs are equal.
+func complexEqual(lhs, rhs *Num) bool {
+	a, b := lhs.parts()
+	c, d := rhs.parts()
+	return a.Cmp(c) == 0 && b.Cmp(d) == 0
+}
+
+func realPart(env *Environment, n *Num) (*Num, error) {
+	re, _ := n.parts()
+	return NewNum(re), nil
+}
+
+func imagPart(env *Environment, n *Num) (*Num, error) {
+	_, im := n.parts()
+	return NewNum(im), nil
+}
+
+func conj(env *Environment, n *Num) (*Num, error) {
+	if !n.complex() {
+		return n, nil
+	}
+	return NewComplex(n.Value, env.float().Neg(n.Imag)), nil
+}
+
+// angle is the angle of n from the positive real axis, in radians from -π to
+// π.
+func angle(env *Environment, n *Num) (*Num, error) {
+	re, im := n.parts()
+	return approx(env, func(prec uint) (*big.Float, error) {
+		return bigAtan2(im, re, prec), nil
+	})
+}
+
+var real_ = &Fn{
+	Argc: 1,
+	Impl: numunop(realPart),
+}
+
+var imag_ = &Fn{
+	Argc: 1,
+	Impl: numunop(imagPart),
+}
+
+var conjugate = &Fn{
+	Argc: 1,
+	Impl: numunop(conj),
+}
+
+var phase = &Fn{
+	Argc: 1,
+	Impl: numunop(angle),
+}
@@ -0,0 +1,223 @@
+package value
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Tables are read from and written to CSV files, or TSV files when their
+// name ends in .tsv. Every record of a file is a row of a matrix, whose
+// fields are numbers when they hold one in a form that Stringify prints
+// numbers in or in a usual decimal form, such as .5, +3, 1. and ¯2, and
+// strings otherwise. Fields have no type of their own, so a string that
+// writecsv writes is read back as a number when it holds one, as '12' does,
+// and a character as a string.
+
+// comma returns the field separator of the file called path.
+func comma(path string) rune {
+	if strings.EqualFold(filepath.Ext(path), ".tsv") {
+		return '\t'
+	}
+	return ','
+}
+
+// filename returns the file name held by val.
+func filename(val Value) (string, error) {
+	arr := val.(*Arr)
+	if !arr.isStr() || arr.Rank() != 1 {
+		return "", fmt.Errorf("expecting a file name but got %s", arr.Stringify())
+	}
+	return arr.text(), nil
+}
+
+// readTable reads every record of the file called name.
+func readTable(name string) ([][]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = comma(name)
+	return r.ReadAll()
+}
+
+// table lays records out as a matrix of their fields.
+func table(env *Environment, records [][]string) *Arr {
+	var cols int
+	if len(records) > 0 {
+		cols = len(records[0])
+	}
+
+	arr := &Arr{Shape: []int{len(records), cols}, Values: make([]Value, 0, len(records)*cols)}
+	for _, record := range records {
+		for _, field := range record {
+			arr.Values = append(arr.Values, parseField(env, field))
+		}
+	}
+	return pack(arr)
+}
+
+func parseField(env *Environment, field string) Value {
+	if num, err := parseNum(decimal(strings.TrimSpace(field)), env.Precision()); err == nil {
+		return num
+	}
+	return NewStr(field)
+}
+
+// decimal rewrites the real numbers in text into the form that parseNum
+// reads, with a high minus as a minus sign, no plus sign, and a digit on
+// both sides of a decimal point.
+func decimal(text string) string {
+	text = strings.Replace(text, "¯", "-", -1)
+	if i := strings.IndexAny(text, "jJ"); i != -1 {
+		return decimal(text[:i]) + text[i:i+1] + decimal(text[i+1:])
+	}
+
+	var sign string
+	if strings.HasPrefix(text, "-") || strings.HasPrefix(text, "+") && len(text) > 1 && text[1] != 'I' {
+		sign, text = text[:1], text[1:]
+	}
+	if sign == "+" {
+		sign = ""
+	}
+	if i := strings.Index(text, "."); i != -1 {
+		before := i > 0 && isDigit(text[i-1])
+		after := i+1 < len(text) && isDigit(text[i+1])
+		if !before && after {
+			text = text[:i] + "0" + text[i:]
+		} else if before && !after {
+			text = text[:i] + text[i+1:]
+		}
+	}
+	return sign + text
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// formatField prints an item of an array as a field of a record.
+func formatField(val Value) (string, error) {
+	switch v := val.(type) {
+	case *Num, *Char:
+		return v.Stringify(), nil
+	case *Arr:
+		if v.isStr() && v.Rank() == 1 {
+			return v.text(), nil
+		} else if v.Len() == 0 {
+			return "", nil
+		}
+	}
+	return "", fmt.Errorf("cannot write %s as a field", val.Stringify())
+}
+
+// readcsv reads a file as a matrix with a row for every record.
+var readcsv = &Fn{
+	Argc: 1,
+	Impl: fntable{
+		sig(TArr): func(env *Environment, vals ...Value) (Value, error) {
+			name, err := filename(vals[0])
+			if err != nil {
+				return nil, err
+			}
+
+			records, err := readTable(name)
+			if err != nil {
+				return nil, err
+			}
+			return table(env, records), nil
+		},
+	},
+}
+
+// readcsvh reads a file whose first record holds the names of its columns,
+// giving a vector of the names, as strings, and the matrix of the rest.
+var readcsvh = &Fn{
+	Argc: 1,
+	Impl: fntable{
+		sig(TArr): func(env *Environment, vals ...Value) (Value, error) {
+			name, err := filename(vals[0])
+			if err != nil {
+				return nil, err
+			}
+
+			records, err := readTable(name)
+			if err != nil {
+				return nil, err
+			} else if len(records) == 0 {
+				return nil, fmt.Errorf("%s has no header", name)
+			}
+
+			header := &Arr{Values: make([]Value, len(records[0]))}
+			for i, field := range records[0] {
+				header.Values[i] = NewStr(field)
+			}
+			return &Arr{Values: []Value{header, table(env, records[1:])}}, nil
+		},
+	},
+}
+
+// writecsv writes a matrix to a file, a record for every row, giving the
+// number of records written. Vectors are written as a single record and
+// anything else as a single field.
+var writecsv = &Fn{
+	Argc: 2,
+	Impl: fntable{
+		sig(TArr, TNum):  writeTable,
+		sig(TArr, TChar): writeTable,
+		sig(TArr, TArr):  writeTable,
+	},
+}
+
+func writeTable(env *Environment, vals ...Value) (Value, error) {
+	name, err := filename(vals[0])
+	if err != nil {
+		return nil, err
+	}
+
+	arr, ok := vals[1].(*Arr)
+	if !ok || arr.isStr() && arr.Rank() == 1 {
+		arr = &Arr{Shape: []int{1, 1}, Values: []Value{vals[1]}}
+	}
+
+	dims := arr.Dims()
+	switch len(dims) {
+	case 0:
+		dims = []int{1, 1}
+	case 1:
+		dims = []int{1, dims[0]}
+	case 2:
+	default:
+		return nil, errors.New("cannot write an array of a rank higher than 2")
+	}
+
+	records := make([][]string, dims[0])
+	for r := range records {
+		records[r] = make([]string, dims[1])
+		for c := range records[r] {
+			if records[r][c], err = formatField(arr.At(r*dims[1] + c)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(f)
+	w.Comma = comma(name)
+	if err := w.WriteAll(records); err != nil {
+		f.Close()
+		return nil, err
+	} else if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return NewInt(int64(len(records))), nil
+}
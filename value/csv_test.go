@@ -0,0 +1,134 @@
+package value
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		label  string
+		name   string
+		input  string
+		fn     *Fn
+		output string
+	}{
+		{"numbers", "nums.csv", "1,2.5\n3,-4\n", readcsv, "1 2.5\n  3  -4"},
+		{"large integers", "ints.csv", "1000000,18446744073709551616\n", readcsv, "1000000 18446744073709551616"},
+		{"mixed fields", "mixed.csv", "a,1\n\"b,c\",2\n", readcsv, "  (a) 1\n  (b,c) 2"},
+		{"tabs", "nums.tsv", "1\t2\n3\t4\n", readcsv, "1 2\n  3 4"},
+		{"exact numbers", "exact.csv", "1/3,2J3\n", readcsv, "1/3 2J3"},
+		{"empty", "empty.csv", "", readcsv, ""},
+		{"header", "header.csv", "x,y\n1,2\n", readcsvh, "((x) (y)) (1 2)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			env := NewEnvironment()
+			name := filepath.Join(dir, test.name)
+			if err := ioutil.WriteFile(name, []byte(test.input), 0644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			val, err := test.fn.Dispatch(env, NewStr(name))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if val.Stringify() != test.output {
+				t.Errorf("invalid result:\nexpected: %s\nreturned: %s", test.output, val.Stringify())
+			}
+
+			if test.fn != readcsv {
+				return
+			}
+			out := filepath.Join(dir, "out"+filepath.Ext(test.name))
+			if _, err := writecsv.Dispatch(env, NewStr(out), val); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			written, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if string(written) != test.input {
+				t.Errorf("invalid file written:\nexpected: %q\nreturned: %q", test.input, written)
+			}
+		})
+	}
+}
+
+func TestParseField(t *testing.T) {
+	tests := []struct {
+		field  string
+		output string
+		num    bool
+	}{
+		{"12", "12", true},
+		{" 2.5 ", "2.5", true},
+		{".5", "0.5", true},
+		{"-.5", "-0.5", true},
+		{"+3", "3", true},
+		{"1.", "1", true},
+		{"¯2", "-2", true},
+		{"1.e¯2", "0.01", true},
+		{"¯1.5J+.5", "-1.5J0.5", true},
+		{"¯1/3", "-1/3", true},
+		{"+Inf", "+Inf", true},
+		{"+", "+", false},
+		{"¯", "¯", false},
+		{".", ".", false},
+		{"1.2.3", "1.2.3", false},
+		{"12 apples", "12 apples", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.field, func(t *testing.T) {
+			val := parseField(NewEnvironment(), test.field)
+			if _, num := val.(*Num); num != test.num {
+				t.Errorf("expecting %q to be read as a number=%v", test.field, test.num)
+			} else if val.Stringify() != test.output {
+				t.Errorf("invalid field:\nexpected: %s\nreturned: %s", test.output, val.Stringify())
+			}
+		})
+	}
+}
+
+func TestCSVErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ragged := filepath.Join(dir, "ragged.csv")
+	if err := ioutil.WriteFile(ragged, []byte("1,2\n3\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		label string
+		fn    *Fn
+		vals  []Value
+		err   string
+	}{
+		{"ragged records", readcsv, []Value{NewStr(ragged)}, "record on line 2: wrong number of fields"},
+		{"name that is not a string", readcsv, []Value{&Arr{Values: []Value{NewInt(1)}}}, "expecting a file name but got 1"},
+		{"missing file", readcsvh, []Value{NewStr(filepath.Join(dir, "none.csv"))}, "open " + filepath.Join(dir, "none.csv") + ": no such file or directory"},
+		{"array of rank 3", writecsv, []Value{NewStr(filepath.Join(dir, "out.csv")), &Arr{Shape: []int{1, 1, 1}, Values: []Value{NewInt(1)}}}, "cannot write an array of a rank higher than 2"},
+		{"nested array", writecsv, []Value{NewStr(filepath.Join(dir, "out.csv")), &Arr{Values: []Value{&Arr{Values: []Value{NewInt(1), NewInt(2)}}}}}, "cannot write 1 2 as a field"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			_, err := test.fn.Dispatch(NewEnvironment(), test.vals...)
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expected error %q but got %v", test.err, err)
+			}
+		})
+	}
+}
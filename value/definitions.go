@@ -3,233 +3,920 @@ package value
 import (
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
 )
 
-func numbinop(operation func(*Num, *Num) *Num) fntable {
-	return map[signature]handler{
+// numbinop builds the table for an operator on two numbers that pervades
+// arrays, going into nested ones, and is applied lazily to every item of a
+// generator. A number or array is paired with every item of a generator,
+// while two generators are paired item by item until either one finishes.
+// Generators are only accepted when their items are numbers, arrays, or of
+// an unknown type.
+func numbinop(operation func(*Environment, *Num, *Num) (*Num, error)) fntable {
+	in := func(env *Environment) func(*Num, *Num) (*Num, error) {
+		return func(lhs, rhs *Num) (*Num, error) {
+			return operation(env, lhs, rhs)
+		}
+	}
+
+	pervasive := func(env *Environment, vals ...Value) (Value, error) {
+		return pervade(vals[0], vals[1], in(env))
+	}
+
+	lazy := func(env *Environment, vals ...Value) (Value, error) {
+		lhs, rhs := vals[0], vals[1]
+		elem := pervaded(itemTy(lhs), itemTy(rhs))
+		lgen, lok := lhs.(*Gen)
+		rgen, rok := rhs.(*Gen)
+		switch {
+		case lok && rok:
+			return zipWith(elem, lgen, rgen, func(l, r Value) (Value, error) {
+				return pervade(l, r, in(env))
+			}), nil
+		case lok:
+			return lgen.Map(elem, func(item Value) (Value, bool, error) {
+				res, err := pervade(item, rhs, in(env))
+				return res, false, err
+			}), nil
+		}
+		return rgen.Map(elem, func(item Value) (Value, bool, error) {
+			res, err := pervade(lhs, item, in(env))
+			return res, false, err
+		}), nil
+	}
+
+	table := fntable{
+		sig(TArr, TArr): pervasive,
+		sig(TArr, TNum): pervasive,
+		sig(TNum, TArr): pervasive,
+		sig(TNum, TNum): pervasive,
+	}
+	for _, l := range []ty{TNum, TArr, TUnknown} {
+		gen := genOf(l)
+		table[sig(TNum, gen)] = lazy
+		table[sig(gen, TNum)] = lazy
+		table[sig(TArr, gen)] = lazy
+		table[sig(gen, TArr)] = lazy
+		for _, r := range []ty{TNum, TArr, TUnknown} {
+			table[sig(gen, genOf(r))] = lazy
+		}
+	}
+	return table
+}
+
+// arithop is numbinop for an operation that has a version on machine
+// numbers, which arrays that hold them unboxed are combined with first.
+func arithop(op *arith, operation func(*Environment, *Num, *Num) (*Num, error)) fntable {
+	table := numbinop(operation)
+	for _, s := range []signature{sig(TArr, TArr), sig(TArr, TNum), sig(TNum, TArr)} {
+		pervasive := table[s]
+		table[s] = func(env *Environment, vals ...Value) (Value, error) {
+			if res, ok, err := op.vector(env, vals[0], vals[1], operation); err != nil {
+				return nil, err
+			} else if ok {
+				return res, nil
+			}
+			return pervasive(env, vals...)
+		}
+	}
+	return table
+}
+
+// itemTy is the type of val, or of its items when it is a generator.
+func itemTy(val Value) ty {
+	if gen, ok := val.(*Gen); ok {
+		return gen.elem
+	}
+	return Ty(val)
+}
+
+// pervaded is the type of the result of pervading items of type a and b.
+func pervaded(a, b ty) ty {
+	switch {
+	case a == TUnknown || b == TUnknown:
+		return TUnknown
+	case a == TArr || b == TArr:
+		return TArr
+	}
+	return TNum
+}
+
+// numunop builds the table for a function of one number that is applied to
+// every item of an array, going into nested ones, or generator.
+func numunop(operation func(*Environment, *Num) (*Num, error)) fntable {
+	var apply func(*Environment, Value) (Value, error)
+	apply = func(env *Environment, val Value) (Value, error) {
+		switch v := val.(type) {
+		case *Num:
+			return operation(env, v)
+		case *Arr:
+			return mapArr(v, func(item Value) (Value, error) {
+				return apply(env, item)
+			})
+		}
+		return nil, fmt.Errorf("expecting a number but got %s", Ty(val))
+	}
+
+	table := fntable{
+		sig(TNum): func(env *Environment, vals ...Value) (Value, error) {
+			return apply(env, vals[0])
+		},
+		sig(TArr): func(env *Environment, vals ...Value) (Value, error) {
+			return apply(env, vals[0])
+		},
+	}
+	lazy := func(env *Environment, vals ...Value) (Value, error) {
+		res := vals[0].(*Gen).With(func(item Value) (Value, bool, error) {
+			res, err := apply(env, item)
+			return res, false, err
+		})
+		return res, nil
+	}
+	for _, elem := range []ty{TNum, TArr, TUnknown} {
+		table[sig(genOf(elem))] = lazy
+	}
+	return table
+}
+
+var one = big.NewFloat(1)
+
+func boolean(b bool) *Num {
+	if b {
+		return NewInt(1)
+	}
+	return NewInt(0)
+}
+
+func floor(x *big.Float) *big.Float {
+	i, acc := x.Int(nil)
+	res := new(big.Float).SetPrec(x.Prec()).SetInt(i)
+	if acc == big.Above {
+		res.Sub(res, one)
+	}
+	return res
+}
+
+func ceil(x *big.Float) *big.Float {
+	i, acc := x.Int(nil)
+	res := new(big.Float).SetPrec(x.Prec()).SetInt(i)
+	if acc == big.Below {
+		res.Add(res, one)
+	}
+	return res
+}
+
+// power raises base to exp at the precision of env. Integer exponents are
+// computed by repeated squaring, anything else as e^(exp·log base).
+func power(env *Environment, base, exp *big.Float) (*big.Float, error) {
+	if exp.IsInt() {
+		n, acc := exp.Int64()
+		if acc == big.Exact {
+			if n < 0 && base.Sign() == 0 {
+				return nil, errors.New("division by zero")
+			}
+
+			res := env.float().SetInt64(1)
+			sq := env.float().Set(base)
+			for k := n; k != 0; k /= 2 {
+				if k%2 != 0 {
+					res.Mul(res, sq)
+				}
+				sq.Mul(sq, sq)
+			}
+			if n < 0 {
+				res.Quo(one, res)
+			}
+			if res.IsInf() {
+				return nil, errors.New("result is out of range")
+			}
+			return res, nil
+		}
+	}
+
+	if base.Sign() < 0 {
+		return nil, errors.New("cannot raise a negative number to a fractional power")
+	}
+
+	if base.Sign() == 0 {
+		if exp.Sign() < 0 {
+			return nil, errors.New("division by zero")
+		}
+		return env.float(), nil
+	}
+
+	// The logarithm is multiplied by exp, so its error grows with exp.
+	prec := env.Precision() + guard
+	if e := exponent(exp); e > 0 {
+		prec += uint(e)
+	}
+	lg := bigLog(base, prec)
+	res, err := bigExp(lg.Mul(lg, exp), prec)
+	if err != nil {
+		return nil, err
+	}
+	return env.float().Set(res), nil
+}
+
+var add = &Op{
+	Prec: PrecAdditive,
+	Impl: arithop(addArith, func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+		if res, ok := fast(env, lhs, rhs, addArith); ok {
+			return res, nil
+		}
+		if lhs.complex() || rhs.complex() {
+			return complexAdd(env, lhs, rhs), nil
+		}
+		if x, y, ok := rats(env, lhs, rhs); ok {
+			return newRat(env, new(big.Rat).Add(x, y)), nil
+		}
+		return NewNum(env.float().Add(lhs.Float(), rhs.Float())), nil
+	}),
+}
+
+var sub = &Op{
+	Prec: PrecAdditive,
+	Impl: arithop(subArith, func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+		if res, ok := fast(env, lhs, rhs, subArith); ok {
+			return res, nil
+		}
+		if lhs.complex() || rhs.complex() {
+			return complexSub(env, lhs, rhs), nil
+		}
+		if x, y, ok := rats(env, lhs, rhs); ok {
+			return newRat(env, new(big.Rat).Sub(x, y)), nil
+		}
+		return NewNum(env.float().Sub(lhs.Float(), rhs.Float())), nil
+	}),
+}
+
+var mul = &Op{
+	Prec: PrecMultiplicative,
+	Impl: arithop(mulArith, func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+		if res, ok := fast(env, lhs, rhs, mulArith); ok {
+			return res, nil
+		}
+		if lhs.complex() || rhs.complex() {
+			return complexMul(env, lhs, rhs), nil
+		}
+		if x, y, ok := rats(env, lhs, rhs); ok {
+			return newRat(env, new(big.Rat).Mul(x, y)), nil
+		}
+		return NewNum(env.float().Mul(lhs.Float(), rhs.Float())), nil
+	}),
+}
+
+// div follows APL in defining 0 ÷ 0 as 1. Any other division by zero is an
+// error.
+var div = &Op{
+	Prec: PrecMultiplicative,
+	Impl: arithop(quoArith, func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+		if rhs.complex() || lhs.complex() && rhs.sign() != 0 {
+			return complexQuo(env, lhs, rhs), nil
+		}
+		if rhs.sign() == 0 {
+			if lhs.complex() {
+				return nil, errors.New("division by zero")
+			}
+			if lhs.sign() == 0 {
+				return NewInt(1), nil
+			}
+			return nil, errors.New("division by zero")
+		}
+		// Integers that do not divide evenly are left to the rational or the
+		// float64 path.
+		if res, ok := fast(env, lhs, rhs, quoArith); ok {
+			return res, nil
+		}
+		if x, y, ok := rats(env, lhs, rhs); ok {
+			return newRat(env, new(big.Rat).Quo(x, y)), nil
+		}
+		return NewNum(env.float().Quo(lhs.Float(), rhs.Float())), nil
+	}),
+}
+
+var pow = &Op{
+	Prec:  PrecPower,
+	Assoc: AssocRight,
+	Impl: arithop(powArith, func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+		if res, ok := fast(env, lhs, rhs, powArith); ok {
+			return res, nil
+		}
+		if lhs.complex() || rhs.complex() {
+			return complexPower(env, lhs, rhs)
+		}
+		if x, y, ok := rats(env, lhs, rhs); ok && y.IsInt() && y.Num().IsInt64() {
+			res, err := ratPower(x, y.Num().Int64())
+			if err != nil {
+				return nil, err
+			}
+			return newRat(env, res), nil
+		}
+
+		// Fractional powers of negative numbers are complex.
+		if lhs.sign() < 0 && !rhs.Float().IsInt() {
+			return complexPower(env, lhs, rhs)
+		}
+
+		res, err := power(env, lhs.Float(), rhs.Float())
+		if err != nil {
+			return nil, err
+		}
+		return NewNum(res), nil
+	}),
+}
+
+// residue is APL's `a | b`, the remainder of dividing b by a. The result has
+// the sign of a, and 0 | b is b.
+var residue = &Op{
+	Prec: PrecMultiplicative,
+	Impl: arithop(modArith, func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+		if err := realOnly(lhs, rhs); err != nil {
+			return nil, err
+		}
+		if lhs.sign() == 0 {
+			return rhs, nil
+		}
+		if res, ok := fast(env, lhs, rhs, modArith); ok {
+			return res, nil
+		}
+		if x, y, ok := rats(env, lhs, rhs); ok {
+			q := ratFloor(new(big.Rat).Quo(y, x))
+			return newRat(env, new(big.Rat).Sub(y, q.Mul(q, x))), nil
+		}
+		q := floor(env.float().Quo(rhs.Float(), lhs.Float()))
+		res := env.float().Sub(rhs.Float(), env.float().Mul(lhs.Float(), q))
+		return NewNum(res), nil
+	}),
+}
+
+var min_ = &Op{
+	Prec: PrecMultiplicative,
+	Impl: numbinop(func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+		if err := realOnly(lhs, rhs); err != nil {
+			return nil, err
+		}
+		if cmp(env, lhs, rhs) <= 0 {
+			return lhs, nil
+		}
+		return rhs, nil
+	}),
+}
+
+var max_ = &Op{
+	Prec: PrecMultiplicative,
+	Impl: numbinop(func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+		if err := realOnly(lhs, rhs); err != nil {
+			return nil, err
+		}
+		if cmp(env, lhs, rhs) >= 0 {
+			return lhs, nil
+		}
+		return rhs, nil
+	}),
+}
+
+// compare builds a comparison operator that produces 1 when pred holds for
+// the result of comparing the left argument to the right one, and 0
+// otherwise.
+func compare(pred func(int) bool) *Op {
+	return &Op{
+		Prec: PrecCompare,
+		Impl: numbinop(func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+			if err := realOnly(lhs, rhs); err != nil {
+				return nil, err
+			}
+			return boolean(pred(cmp(env, lhs, rhs))), nil
+		}),
+	}
+}
+
+// equality builds = when want is set and ≠ otherwise. Unlike the other
+// comparisons, they accept complex numbers, which have no order.
+func equality(want bool) *Op {
+	return &Op{
+		Prec: PrecCompare,
+		Impl: numbinop(func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+			if lhs.complex() || rhs.complex() {
+				return boolean(complexEqual(lhs, rhs) == want), nil
+			}
+			return boolean((cmp(env, lhs, rhs) == 0) == want), nil
+		}),
+	}
+}
+
+var (
+	lt = compare(func(c int) bool { return c < 0 })
+	le = compare(func(c int) bool { return c <= 0 })
+	eq = equality(true)
+	ge = compare(func(c int) bool { return c >= 0 })
+	gt = compare(func(c int) bool { return c > 0 })
+	ne = equality(false)
+)
+
+// truth returns the boolean a number stands for, only 0 and 1 being valid.
+func truth(num *Num) (bool, error) {
+	switch n, ok := num.int64(); {
+	case ok && n == 0:
+		return false, nil
+	case ok && n == 1:
+		return true, nil
+	}
+	return false, fmt.Errorf("expecting a boolean but got %s", num.Stringify())
+}
+
+var not_ = &Fn{
+	Argc: 1,
+	Impl: numunop(func(env *Environment, arg *Num) (*Num, error) {
+		b, err := truth(arg)
+		if err != nil {
+			return nil, err
+		}
+		return boolean(!b), nil
+	}),
+}
+
+// count returns the number of times compress should repeat an item.
+func count(num *Num) (int, error) {
+	n, ok := num.int64()
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("expecting a non-negative integer but got %s", num.Stringify())
+	} else if int64(int(n)) != n {
+		return 0, fmt.Errorf("count %s is too large", num.Stringify())
+	}
+	return int(n), nil
+}
+
+// compress is APL's `mask / data`, which repeats every item of data as many
+// times as the matching item of mask says. A mask of zeros and ones selects
+// items. Generators are filtered lazily, with a generator of zeros and ones
+// as the mask.
+var compress = &Op{
+	Impl: fntable{
 		sig(TArr, TArr): func(env *Environment, vals ...Value) (Value, error) {
-			lhs := vals[0].(*Arr)
-			rhs := vals[1].(*Arr)
-			if len(lhs.Values) != len(rhs.Values) {
+			mask := vals[0].(*Arr)
+			data := vals[1].(*Arr)
+			if mask.Len() != data.Len() {
 				return nil, fmt.Errorf("array sizes do not match, left has %d items but right has %d",
-					len(lhs.Values), len(rhs.Values))
+					mask.Len(), data.Len())
 			}
-			res := &Arr{Values: make([]*Num, len(lhs.Values))}
-			for i := range lhs.Values {
-				res.Values[i] = operation(lhs.Values[i], rhs.Values[i])
+			res := &Arr{Values: []Value{}}
+			for i, item := range data.Items() {
+				m, ok := mask.At(i).(*Num)
+				if !ok {
+					return nil, fmt.Errorf("expecting a mask of numbers but got %s", Ty(mask.At(i)))
+				}
+				n, err := count(m)
+				if err != nil {
+					return nil, err
+				} else if err := checkItems(len(res.Values)+n, 1); err != nil {
+					return nil, err
+				}
+				for ; n > 0; n-- {
+					res.Values = append(res.Values, item)
+				}
 			}
 			return res, nil
 		},
-		sig(TArr, TNum): func(env *Environment, vals ...Value) (Value, error) {
-			arr := vals[0].(*Arr)
-			num := vals[1].(*Num)
-			res := &Arr{Values: make([]*Num, len(arr.Values))}
-			for i := range arr.Values {
-				res.Values[i] = operation(arr.Values[i], num)
+		sig(TNum, TArr): func(env *Environment, vals ...Value) (Value, error) {
+			n, err := count(vals[0].(*Num))
+			if err != nil {
+				return nil, err
+			}
+			data := vals[1].(*Arr)
+			if err := checkItems(n, data.Len()); err != nil {
+				return nil, err
+			}
+			res := &Arr{Values: make([]Value, 0, n*data.Len())}
+			for _, item := range data.Items() {
+				for i := 0; i < n; i++ {
+					res.Values = append(res.Values, item)
+				}
 			}
 			return res, nil
 		},
-		sig(TNum, TArr): func(env *Environment, vals ...Value) (Value, error) {
-			num := vals[0].(*Num)
-			arr := vals[1].(*Arr)
-			res := &Arr{Values: make([]*Num, len(arr.Values))}
-			for i := range arr.Values {
-				res.Values[i] = operation(num, arr.Values[i])
+		sig(TNum, TNum): func(env *Environment, vals ...Value) (Value, error) {
+			n, err := count(vals[0].(*Num))
+			if err != nil {
+				return nil, err
+			} else if err := checkItems(n, 1); err != nil {
+				return nil, err
+			}
+			res := &Arr{Values: make([]Value, n)}
+			for i := range res.Values {
+				res.Values[i] = vals[1]
 			}
 			return res, nil
 		},
 		sig(TNum, TGen): func(env *Environment, vals ...Value) (Value, error) {
-			lhs := vals[0].(*Num)
+			keep, err := truth(vals[0].(*Num))
+			if err != nil {
+				return nil, err
+			}
 			gen := vals[1].(*Gen)
-			res := gen.With(func(val Value, step int) (Value, bool, bool) {
-				// XXX Assumes numbers, fix this
-				rhs := val.(*Num)
-				return operation(lhs, rhs), false, true
-			})
-			return res, nil
-		},
-		sig(TNum, TNum): func(env *Environment, vals ...Value) (Value, error) {
-			lhs := vals[0].(*Num)
-			rhs := vals[1].(*Num)
-			return operation(lhs, rhs), nil
+			if keep {
+				return gen, nil
+			}
+			return emptyGen(gen.elem), nil
 		},
-	}
+		sig(genOf(TNum), TGen):     compressGen,
+		sig(genOf(TUnknown), TGen): compressGen,
+	},
 }
 
-var add = &Op{
-	Impl: numbinop(func(lhs *Num, rhs *Num) *Num {
-		return &Num{Value: big.NewFloat(0).Add(lhs.Value, rhs.Value)}
-	}),
-}
-
-var mul = &Op{
-	Impl: numbinop(func(lhs *Num, rhs *Num) *Num {
-		return &Num{Value: big.NewFloat(0).Mul(lhs.Value, rhs.Value)}
-	}),
+// compressGen filters a generator lazily by a generator of zeros and ones,
+// which may only hold numbers.
+func compressGen(env *Environment, vals ...Value) (Value, error) {
+	mask := vals[0].(*Gen)
+	data := vals[1].(*Gen)
+	res := zipWith(data.elem, mask, data, func(m, val Value) (Value, error) {
+		num, ok := m.(*Num)
+		if !ok {
+			return nil, fmt.Errorf("expecting a mask of numbers but got %s", Ty(m))
+		}
+		keep, err := truth(num)
+		if err != nil || !keep {
+			return nil, err
+		}
+		return val, nil
+	})
+	return res, nil
 }
 
+// range_ is `a .. b`, the integers from a up to but not including b, which
+// is empty when b is not past a.
 var range_ = &Op{
+	Prec: PrecRange,
 	Impl: fntable{
 		sig(TNum, TNum): func(env *Environment, vals ...Value) (Value, error) {
 			a1, a2 := vals[0].(*Num), vals[1].(*Num)
-			min64, _ := a1.Value.Int64()
-			min := int(min64)
-			max64, _ := a2.Value.Int64()
-			max := int(max64)
-			res := &Arr{Values: make([]*Num, max-min)}
-			for i := 0; i < max-min; i++ {
-				res.Values[i] = &Num{Value: big.NewFloat(float64(min + i))}
-			}
-			return res, nil
+			min, _ := a1.int64()
+			max, _ := a2.int64()
+			return counting(min, max)
 		},
 	},
 }
 
+// counting returns the vector of the integers from min up to max.
+func counting(min, max int64) (Value, error) {
+	if max <= min {
+		return intsArr([]int64{}), nil
+	}
+
+	// The difference is negative when it overflows an int64, which
+	// checkItems refuses as well.
+	n := max - min
+	if n > maxItems {
+		n = maxItems + 1
+	}
+	if err := checkItems(int(n), 1); err != nil {
+		return nil, err
+	}
+	res := make([]int64, n)
+	for i := range res {
+		res[i] = min + int64(i)
+	}
+	return intsArr(res), nil
+}
+
+// position converts an index into an offset into a collection of n items,
+// taking the index origin into account. A negative n is a collection of an
+// unknown size, such as a generator.
+func position(env *Environment, val Value, n int) (int, error) {
+	num, ok := val.(*Num)
+	if !ok {
+		return 0, fmt.Errorf("expecting an index but got %s", Ty(val))
+	} else if num.complex() || !num.Float().IsInt() {
+		return 0, fmt.Errorf("index %s is not an integer", num.Stringify())
+	} else if num.sign() < 0 {
+		return 0, fmt.Errorf("index %s is negative", num.Stringify())
+	}
+
+	origin := env.IndexOrigin()
+	i64, exact := num.int64()
+	idx := int(i64) - origin
+	switch {
+	case idx < 0:
+		return 0, fmt.Errorf("index %s is below the index origin of %d", num.Stringify(), origin)
+	case !exact || n >= 0 && idx >= n:
+		return 0, fmt.Errorf("index out of bounds, %s is past the last of %d items", num.Stringify(), n)
+	}
+	return idx, nil
+}
+
+// cell returns the item of a vector, or the major cell of an array of a
+// higher rank, at offset i.
+func cell(arr *Arr, i int) Value {
+	dims := arr.Dims()
+	if len(dims) <= 1 {
+		return arr.At(i)
+	}
+	n := size(dims[1:])
+	return section(arr, dims[1:], i*n, (i+1)*n)
+}
+
+// pick selects the items or major cells of data at the given indexes. A
+// single index selects a single item, while an array of indexes selects an
+// array of items in its shape.
+func pick(env *Environment, idxs Value, data *Arr) (Value, error) {
+	n := 1
+	if data.Rank() > 0 {
+		n = data.Dims()[0]
+	}
+
+	arr, ok := idxs.(*Arr)
+	if !ok {
+		i, err := position(env, idxs, n)
+		if err != nil {
+			return nil, err
+		}
+		return cell(data, i), nil
+	}
+
+	var vals []Value
+	for _, idx := range arr.Items() {
+		i, err := position(env, idx, n)
+		if err != nil {
+			return nil, err
+		}
+
+		if item, ok := cell(data, i).(*Arr); ok && data.Rank() > 1 {
+			vals = append(vals, item.Items()...)
+		} else {
+			vals = append(vals, cell(data, i))
+		}
+	}
+
+	dims := arr.Dims()
+	if data.Rank() > 1 {
+		dims = append(append([]int{}, dims...), data.Dims()[1:]...)
+	}
+	if vals == nil {
+		vals = []Value{}
+	}
+	return shaped(dims, vals), nil
+}
+
+// buffer reads items from gen until it holds enough to cover every index in
+// idxs, so that they can be picked as if from an array.
+func buffer(env *Environment, idxs Value, gen *Gen) (*Arr, error) {
+	last := 0
+	for _, idx := range ravel(idxs).Items() {
+		i, err := position(env, idx, -1)
+		if err != nil {
+			return nil, err
+		}
+		if i+1 > last {
+			last = i + 1
+		}
+	}
+
+	buff := &Arr{Values: make([]Value, 0, last)}
+	it := gen.Iter()
+	for len(buff.Values) < last {
+		val, ok := it.Next()
+		if !ok {
+			break
+		}
+		buff.Values = append(buff.Values, val)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return buff, nil
+}
+
+// access is `idx @ data`, selecting items by their index, starting at ⎕IO. A
+// number is always taken to be the index, so `data @ 2` works as well. When
+// both sides are collections the left one holds the indexes. Generators are
+// read up to the largest index, or indexed lazily when they hold the
+// indexes.
 var access = &Op{
+	Prec: PrecSelect,
 	Impl: fntable{
+		sig(TNum, TArr): func(env *Environment, vals ...Value) (Value, error) {
+			return pick(env, vals[0], vals[1].(*Arr))
+		},
+		sig(TArr, TNum): func(env *Environment, vals ...Value) (Value, error) {
+			return pick(env, vals[1], vals[0].(*Arr))
+		},
 		sig(TArr, TArr): func(env *Environment, vals ...Value) (Value, error) {
-			orig := vals[0].(*Arr)
-			idxs := vals[1].(*Arr)
-			res := &Arr{Values: make([]*Num, len(idxs.Values))}
-			size := len(orig.Values)
-			for i, nidx := range idxs.Values {
-				f64, _ := nidx.Value.Float64()
-				idx := int(f64)
-				if idx >= size {
-					return nil, errors.New("index out of bounds")
-				}
-				res.Values[i] = orig.Values[idx]
+			return pick(env, vals[0], vals[1].(*Arr))
+		},
+		sig(TNum, TGen): func(env *Environment, vals ...Value) (Value, error) {
+			buff, err := buffer(env, vals[0], vals[1].(*Gen))
+			if err != nil {
+				return nil, err
 			}
-			return res, nil
+			return pick(env, vals[0], buff)
 		},
-		sig(TArr, TNum): func(env *Environment, vals ...Value) (Value, error) {
-			arr := vals[0].(*Arr)
-			num := vals[1].(*Num)
-			f64, _ := num.Value.Float64()
-			idx := int(f64)
-			if idx >= len(arr.Values) {
-				return nil, errors.New("index out of bounds")
+		sig(TGen, TNum): func(env *Environment, vals ...Value) (Value, error) {
+			buff, err := buffer(env, vals[1], vals[0].(*Gen))
+			if err != nil {
+				return nil, err
 			}
-			return arr.Values[idx], nil
+			return pick(env, vals[1], buff)
 		},
-		sig(TNum, TArr): func(env *Environment, vals ...Value) (Value, error) {
-			arr := vals[1].(*Arr)
-			num := vals[0].(*Num)
-			f64, _ := num.Value.Float64()
-			idx := int(f64)
-			if idx >= len(arr.Values) {
-				return nil, errors.New("index out of bounds")
+		sig(TArr, TGen): func(env *Environment, vals ...Value) (Value, error) {
+			buff, err := buffer(env, vals[0], vals[1].(*Gen))
+			if err != nil {
+				return nil, err
 			}
-			return arr.Values[idx], nil
+			return pick(env, vals[0], buff)
+		},
+		sig(TGen, TArr): func(env *Environment, vals ...Value) (Value, error) {
+			idxs := vals[0].(*Gen)
+			data := vals[1].(*Arr)
+			res := idxs.Map(TUnknown, func(idx Value) (Value, bool, error) {
+				val, err := pick(env, idx, data)
+				return val, false, err
+			})
+			return res, nil
 		},
 	},
 }
 
 var set = &Op{
+	Prec: PrecSelect,
 	Impl: fntable{
 		sig(TArr, TNum): func(env *Environment, vals ...Value) (Value, error) {
 			arr := vals[0].(*Arr)
 			num := vals[1].(*Num)
-			res := &Arr{Values: make([]*Num, len(arr.Values))}
-			for i := range arr.Values {
-				res.Values[i] = &Num{Value: num.Value}
+			res := &Arr{Shape: arr.Shape, Values: make([]Value, arr.Len())}
+			for i := range res.Values {
+				res.Values[i] = num
 			}
 			return res, nil
 		},
 		sig(TNum, TArr): func(env *Environment, vals ...Value) (Value, error) {
 			num := vals[0].(*Num)
 			arr := vals[1].(*Arr)
-			res := &Arr{Values: make([]*Num, len(arr.Values))}
-			for i := range arr.Values {
-				res.Values[i] = &Num{Value: num.Value}
+			res := &Arr{Shape: arr.Shape, Values: make([]Value, arr.Len())}
+			for i := range res.Values {
+				res.Values[i] = num
 			}
 			return res, nil
 		},
 	},
 }
 
+func negative(env *Environment, arg *Num) (*Num, error) {
+	if x, ok := ratOf(env, arg); ok {
+		return newRat(env, new(big.Rat).Neg(x)), nil
+	} else if arg.complex() {
+		return NewComplex(env.float().Neg(arg.Value), env.float().Neg(arg.Imag)), nil
+	}
+	// Zero is left to big.Float, which keeps its sign.
+	if arg.kind == kindInt && arg.i != 0 && arg.i != math.MinInt64 {
+		return NewInt(-arg.i), nil
+	} else if exactInts(env) && (arg.kind == kindBigInt || arg.kind == kindInt) {
+		i, _ := arg.bigInt()
+		return newInteger(i.Neg(i)), nil
+	}
+	return NewNum(env.float().Neg(arg.Float())), nil
+}
+
+func absolute(env *Environment, arg *Num) (*Num, error) {
+	if x, ok := ratOf(env, arg); ok {
+		return newRat(env, new(big.Rat).Abs(x)), nil
+	} else if arg.complex() {
+		return complexAbs(env, arg), nil
+	}
+	if arg.kind == kindInt && arg.i >= 0 {
+		return arg, nil
+	} else if arg.kind == kindInt && arg.i != math.MinInt64 {
+		return NewInt(-arg.i), nil
+	} else if exactInts(env) && (arg.kind == kindBigInt || arg.kind == kindInt) {
+		i, _ := arg.bigInt()
+		return newInteger(i.Abs(i)), nil
+	}
+	return NewNum(env.float().Abs(arg.Float())), nil
+}
+
 var neg = &Fn{
 	Argc: 1,
-	Impl: fntable{
-		sig(TNum): func(env *Environment, vals ...Value) (Value, error) {
-			arg := vals[0].(*Num)
-			res := big.NewFloat(0).Neg(arg.Value)
-			return &Num{Value: res}, nil
-		},
-	},
+	Impl: numunop(negative),
+}
+
+var reciprocal = &Fn{
+	Argc: 1,
+	Impl: numunop(func(env *Environment, arg *Num) (*Num, error) {
+		if arg.complex() {
+			return complexQuo(env, NewInt(1), arg), nil
+		}
+		if arg.sign() == 0 {
+			return nil, errors.New("division by zero")
+		}
+		if res, ok := fast(env, NewInt(1), arg, quoArith); ok {
+			return res, nil
+		}
+		if x, ok := ratOf(env, arg); ok {
+			return newRat(env, new(big.Rat).Inv(x)), nil
+		}
+		return NewNum(env.float().Quo(one, arg.Float())), nil
+	}),
+}
+
+var floor_ = &Fn{
+	Argc: 1,
+	Impl: numunop(func(env *Environment, arg *Num) (*Num, error) {
+		if err := realOnly(arg); err != nil {
+			return nil, err
+		}
+		if x, ok := ratOf(env, arg); ok {
+			return newRat(env, ratFloor(x)), nil
+		}
+		if arg.kind == kindInt || arg.kind == kindBigInt {
+			return arg, nil
+		}
+		return NewNum(floor(arg.Float())), nil
+	}),
+}
+
+var ceil_ = &Fn{
+	Argc: 1,
+	Impl: numunop(func(env *Environment, arg *Num) (*Num, error) {
+		if err := realOnly(arg); err != nil {
+			return nil, err
+		}
+		if x, ok := ratOf(env, arg); ok {
+			return newRat(env, ratCeil(x)), nil
+		}
+		if arg.kind == kindInt || arg.kind == kindBigInt {
+			return arg, nil
+		}
+		return NewNum(ceil(arg.Float())), nil
+	}),
 }
 
 var abs = &Fn{
 	Argc: 1,
-	Impl: fntable{
-		sig(TNum): func(env *Environment, vals ...Value) (Value, error) {
-			arg := vals[0].(*Num)
-			res := big.NewFloat(0).Abs(arg.Value)
-			return &Num{Value: res}, nil
-		},
-	},
+	Impl: numunop(absolute),
 }
 
 var until = &Fn{
 	Argc: 1,
 	Impl: fntable{
 		sig(TNum): func(env *Environment, vals ...Value) (Value, error) {
-			arg := vals[0].(*Num)
-			max64, _ := arg.Value.Int64()
-			max := int(max64)
-			res := &Arr{Values: make([]*Num, max)}
-			for i := 0; i < max; i++ {
-				res.Values[i] = &Num{Value: big.NewFloat(float64(i))}
-			}
-			return res, nil
+			max, _ := vals[0].(*Num).int64()
+			return counting(0, max)
 		},
 	},
 }
 
+// g_until is `...$ n`, a generator that counts from 0 up to n. Given an
+// array it streams the items, or the rows of a matrix, instead.
 var g_until = &Fn{
 	Argc: 1,
 	Impl: fntable{
+		sig(TArr): func(env *Environment, vals ...Value) (Value, error) {
+			return toGen(vals[0]), nil
+		},
 		sig(TNum): func(env *Environment, vals ...Value) (Value, error) {
 			arg := vals[0].(*Num)
-			max64, _ := arg.Value.Int64()
-			max := int(max64)
-			res := &Gen{
-				ty:   TNum,
-				done: false,
-				curr: &Num{Value: big.NewFloat(0)},
-				next: func(curr Value, size int) (Value, bool, bool) {
-					num, _ := curr.(*Num)
-					curr64, _ := num.Value.Int64()
-					if int(curr64)+size > max {
-						return nil, true, false
+			max64, _ := arg.int64()
+			res := newGen(TNum, func() pull {
+				i := int64(0)
+				return func() (Value, bool, error) {
+					if i >= max64 {
+						return nil, false, nil
 					}
-					stepped := big.NewFloat(float64(int(curr64) + size))
-					return &Num{Value: stepped}, false, true
-				},
-			}
+					i++
+					return NewInt(i - 1), true, nil
+				}
+			})
 			return res, nil
 		},
 	},
 }
 
 var g_take = &Op{
+	Prec: PrecSelect,
 	Impl: fntable{
 		sig(TGen, TNum): func(env *Environment, vals ...Value) (Value, error) {
 			gen := vals[0].(*Gen)
 			num := vals[1].(*Num)
 
-			max64, _ := num.Value.Int64()
-			max := int(max64)
+			max, err := count(num)
+			if err != nil {
+				return nil, err
+			}
 
-			res := &Arr{Values: make([]*Num, max)}
+			// A generator that finishes early gives fewer items, so they are
+			// only allocated as they are read.
+			res := &Arr{Values: []Value{}}
+			it := gen.Iter()
 			for i := 0; i < max; i++ {
-				val, ok := gen.Next()
+				val, ok := it.Next()
 				if !ok {
-					return nil, fmt.Errorf("error on step %d", i)
+					break
+				} else if err := checkItems(i+1, 1); err != nil {
+					return nil, err
 				}
-				// XXX Assumes numbers, fix this
-				res.Values[i] = val.(*Num)
+				res.Values = append(res.Values, val)
+			}
+			if err := it.Err(); err != nil {
+				return nil, err
 			}
 
 			return res, nil
@@ -241,8 +928,11 @@ var len_ = &Fn{
 	Argc: 1,
 	Impl: fntable{
 		sig(TArr): func(env *Environment, vals ...Value) (Value, error) {
-			arg := vals[0].(*Arr)
-			return &Num{Value: big.NewFloat(float64(len(arg.Values)))}, nil
+			dims := vals[0].(*Arr).Dims()
+			if len(dims) == 0 {
+				return NewInt(1), nil
+			}
+			return NewInt(int64(dims[0])), nil
 		},
 	},
 }
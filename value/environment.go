@@ -1,43 +1,110 @@
 package value
 
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
 type Environment struct {
 	ops map[string]*Op
 	fns map[string]*Fn
 	val map[string]Value
+
+	parent *Environment
+	rtl    bool
+}
+
+// Extend creates a child scope. Lookups fall back to env for anything not
+// defined in the child, while definitions only affect the child.
+func (env *Environment) Extend() *Environment {
+	return &Environment{
+		ops:    make(map[string]*Op),
+		fns:    make(map[string]*Fn),
+		val:    make(map[string]Value),
+		parent: env,
+		rtl:    env.rtl,
+	}
 }
 
 func (env *Environment) HasOp(id string) bool {
-	_, ok := env.ops[id]
-	return ok
+	for ; env != nil; env = env.parent {
+		if _, ok := env.ops[id]; ok {
+			return true
+		}
+	}
+	return false
 }
 
 func (env *Environment) HasFn(id string) bool {
-	_, ok := env.fns[id]
-	return ok
+	for ; env != nil; env = env.parent {
+		if _, ok := env.fns[id]; ok {
+			return true
+		}
+	}
+	return false
 }
 
 func (env *Environment) HasVal(id string) bool {
-	_, ok := env.val[id]
-	return ok
+	for ; env != nil; env = env.parent {
+		if _, ok := env.val[id]; ok {
+			return true
+		}
+	}
+	return false
 }
 
 func (env *Environment) GetOp(id string) *Op {
-	return env.ops[id]
+	for ; env != nil; env = env.parent {
+		if op, ok := env.ops[id]; ok {
+			return op
+		}
+	}
+	return nil
 }
 
 func (env *Environment) GetFn(id string) *Fn {
-	return env.fns[id]
+	for ; env != nil; env = env.parent {
+		if fn, ok := env.fns[id]; ok {
+			return fn
+		}
+	}
+	return nil
 }
 
 func (env *Environment) GetVal(id string) Value {
-	return env.val[id]
+	for ; env != nil; env = env.parent {
+		if val, ok := env.val[id]; ok {
+			return val
+		}
+	}
+	return nil
 }
 
+// builtins is the environment that NewEnvironment creates, which IsBuiltin
+// looks names up in.
+var builtins = NewEnvironment()
+
+// IsBuiltin reports whether id names one of the functions or operators that
+// every environment starts with. They cannot be rebound, since a value bound
+// to the same name would remove them from the environment for good.
+func IsBuiltin(id string) bool {
+	_, fn := builtins.fns[id]
+	_, op := builtins.ops[id]
+	return fn || op
+}
+
+// SetVal binds id to a value. Any function bound to the same name in this
+// scope is removed so that the parser stops treating id as a function.
 func (env *Environment) SetVal(id string, val Value) {
+	delete(env.fns, id)
 	env.val[id] = val
 }
 
+// SetFn binds id to a function, replacing any value bound to the same name
+// in this scope.
 func (env *Environment) SetFn(id string, fn *Fn) {
+	delete(env.val, id)
 	env.fns[id] = fn
 }
 
@@ -45,26 +112,260 @@ func (env *Environment) SetOp(id string, op *Op) {
 	env.ops[id] = op
 }
 
+// maxPrec is the highest precision ⎕FPREC may be set to, which is about
+// 20000 decimal digits. Arithmetic at big.MaxPrec bits would run out of
+// memory long before it finished.
+const maxPrec = 1 << 16
+
+// sysvars are the system variables. Their names start with ⎕, they are shared
+// by every scope, and every assignment to them is checked first.
+var sysvars = map[string]func(Value) error{
+	"⎕IO": func(val Value) error {
+		if !between(val, 0, 1) {
+			return fmt.Errorf("⎕IO must be 0 or 1 but got %s", val.Stringify())
+		}
+		return nil
+	},
+	"⎕FPREC": func(val Value) error {
+		if !between(val, 1, maxPrec) {
+			return fmt.Errorf("⎕FPREC must be a number of bits from 1 to %d but got %s", maxPrec, val.Stringify())
+		}
+		return nil
+	},
+	"⎕RM": func(val Value) error {
+		if !between(val, 0, int64(big.ToPositiveInf)) {
+			return fmt.Errorf("⎕RM must be a rounding mode from 0 to %d but got %s",
+				big.ToPositiveInf, val.Stringify())
+		}
+		return nil
+	},
+	"⎕EXACT": func(val Value) error {
+		if !between(val, 0, 1) {
+			return fmt.Errorf("⎕EXACT must be 0 or 1 but got %s", val.Stringify())
+		}
+		return nil
+	},
+	"⎕FRAC": func(val Value) error {
+		if !between(val, 0, 1) {
+			return fmt.Errorf("⎕FRAC must be 0 or 1 but got %s", val.Stringify())
+		}
+		return nil
+	},
+	"⎕PP": func(val Value) error {
+		if !between(val, 0, 1000) {
+			return fmt.Errorf("⎕PP must be a number of digits from 0 to 1000 but got %s", val.Stringify())
+		}
+		return nil
+	},
+}
+
+// between reports whether val is an integer from min to max.
+func between(val Value, min, max int64) bool {
+	num, ok := val.(*Num)
+	if !ok {
+		return false
+	}
+	n, isInt := num.int64()
+	return isInt && n >= min && n <= max
+}
+
+// sysint returns the value of the integer system variable id, or def when it
+// is not set.
+func (env *Environment) sysint(id string, def int64) int64 {
+	num, ok := env.GetVal(id).(*Num)
+	if !ok {
+		return def
+	}
+	n, _ := num.int64()
+	return n
+}
+
+// IsSys reports whether id names a system variable.
+func IsSys(id string) bool {
+	return strings.HasPrefix(id, "⎕")
+}
+
+func (env *Environment) root() *Environment {
+	for env.parent != nil {
+		env = env.parent
+	}
+	return env
+}
+
+// SetSys sets a system variable, failing if id is not one or val is not a
+// valid setting for it.
+func (env *Environment) SetSys(id string, val Value) error {
+	check, ok := sysvars[id]
+	if !ok {
+		return fmt.Errorf("%s is not a system variable", id)
+	} else if err := check(val); err != nil {
+		return err
+	}
+	env.root().val[id] = val
+	return nil
+}
+
+// IndexOrigin is the index of the first item of an array, set by ⎕IO.
+func (env *Environment) IndexOrigin() int {
+	return int(env.sysint("⎕IO", 0))
+}
+
+// Precision is the number of mantissa bits that number literals and the
+// results of arithmetic are kept in, set by ⎕FPREC. Arithmetic on floats
+// that are not integers uses float64 values only when it is 53, and
+// big.Float values at any other precision, including the default of 64.
+func (env *Environment) Precision() uint {
+	return uint(env.sysint("⎕FPREC", 64))
+}
+
+// RoundingMode is how results are rounded to the precision, set by ⎕RM using
+// the order of big.RoundingMode, so 0 rounds to the nearest even number.
+func (env *Environment) RoundingMode() big.RoundingMode {
+	return big.RoundingMode(env.sysint("⎕RM", int64(big.ToNearestEven)))
+}
+
+// PrintPrecision is the number of significant digits numbers are printed
+// with, set by ⎕PP. With 0, numbers are printed with as many digits as are
+// needed to tell them apart from any other number of the same precision.
+func (env *Environment) PrintPrecision() int {
+	return int(env.sysint("⎕PP", 0))
+}
+
+// Exact reports whether arithmetic on integers and rationals is kept exact,
+// set by ⎕EXACT. In exact mode, number literals such as 0.1 are rationals,
+// and +, -, ×, ÷ and integer powers of rationals give rationals, while any
+// other operation gives an approximation.
+func (env *Environment) Exact() bool {
+	return env.sysint("⎕EXACT", 0) == 1
+}
+
+// Fractions reports whether rationals are printed as fractions, such as 1/3,
+// rather than as decimals, set by ⎕FRAC.
+func (env *Environment) Fractions() bool {
+	return env.sysint("⎕FRAC", 1) == 1
+}
+
+// float creates a number with the precision and rounding mode of env, which
+// arithmetic stores its results in.
+func (env *Environment) float() *big.Float {
+	return new(big.Float).SetPrec(env.Precision()).SetMode(env.RoundingMode())
+}
+
+// RightToLeft reports whether infix operators are parsed in strict APL
+// order, where every operator has the same precedence and groups to the
+// right.
+func (env *Environment) RightToLeft() bool {
+	return env.rtl
+}
+
+func (env *Environment) SetRightToLeft(rtl bool) {
+	env.rtl = rtl
+}
+
+// Binding returns the precedence and associativity the parser should use for
+// the infix operator id, taking the right-to-left mode into account.
+// Assignment always binds loosest so that `a := 1 + 2` works in both modes.
+func (env *Environment) Binding(id string) (int, Assoc) {
+	op := env.GetOp(id)
+	if op == nil {
+		return 0, AssocLeft
+	}
+
+	prec := op.Prec
+	if prec == 0 {
+		prec = PrecDefault
+	}
+
+	if env.rtl {
+		if prec == PrecAssign {
+			return PrecAssign, AssocRight
+		}
+		return PrecDefault, AssocRight
+	}
+	return prec, op.Assoc
+}
+
 func NewEnvironment() *Environment {
 	return &Environment{
-		val: make(map[string]Value),
+		val: map[string]Value{
+			"⎕IO":    NewInt(0),
+			"⎕FPREC": NewInt(64),
+			"⎕RM":    NewInt(0),
+			"⎕PP":    NewInt(0),
+			"⎕EXACT": NewInt(0),
+			"⎕FRAC":  NewInt(1),
+		},
 		ops: map[string]*Op{
 			"!=":  set,
-			"*":   mul,
+			"*":   pow,
 			"+":   add,
+			"-":   sub,
 			"..":  range_,
 			"@":   access,
 			"---": g_take,
+			"×":   mul,
+			"÷":   div,
+			"|":   residue,
+			"⌊":   min_,
+			"⌈":   max_,
+			"<":   lt,
+			"≤":   le,
+			"=":   eq,
+			"≥":   ge,
+			">":   gt,
+			"≠":   ne,
+			"∧":   lcm,
+			"∨":   gcd,
+			"⍟":   logBase,
+			"○":   circle,
+			"!":   binomial,
+			"/":   compress,
+			",":   catenate_,
+			"⍴":   reshape_,
 
 			// Placeholders for special operators
-			":=": &Op{},
+			":=": &Op{Prec: PrecAssign, Assoc: AssocRight},
 		},
 		fns: map[string]*Fn{
+			"-":    neg,
+			"÷":    reciprocal,
+			"|":    abs,
+			"⌊":    floor_,
+			"⌈":    ceil_,
+			"*":    exp_,
+			"⍟":    log_,
+			"○":    pi,
+			"!":    factorial,
+			"~":    not_,
+			",":    ravel_,
+			"⍴":    shape,
+			"⊂":    enclose,
+			"⊃":    first,
 			"...":  until,
 			"...$": g_until,
 			"abs":  abs,
 			"len":  len_,
 			"neg":  neg,
+			"sqrt": sqrt,
+
+			"zip":       zip,
+			"filter":    filter,
+			"takewhile": takeWhile,
+			"dropwhile": dropWhile,
+			"drop":      drop,
+			"iterate":   iterate_,
+
+			"real":      real_,
+			"imag":      imag_,
+			"conjugate": conjugate,
+			"phase":     phase,
+
+			"json⍞": jsonEncode,
+			"json⍎": jsonDecode,
+
+			"readcsv":  readcsv,
+			"readcsvh": readcsvh,
+			"writecsv": writecsv,
 		},
 	}
 }
@@ -8,53 +8,146 @@ import (
 	"github.com/minond/calc/value"
 )
 
-// func fold(env *value.Environment, call *parser.App) (value.Value, error) {
-// 	if len(call.Args) != 2 {
-// 		return nil, fmt.Errorf("expecting 2 arguments but got %d", len(call.Args))
-// 	}
-//
-// 	fnEx, argEx := call.Args[0], call.Args[1]
-// 	fnId, ok := fnEx.(*parser.Id)
-// 	if !ok {
-// 		return nil, errors.New("expecting an identifier")
-// 	} else if !env.HasFn(fnId.Value) {
-// 		return nil, fmt.Errorf("`%s` is not a function", fnId.Value)
-// 	}
-//
-// 	fn := env.GetFn(fnId.Value)
-// 	arg, err := Eval(env, argEx)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-//
-// 	return fn.Apply(env, arg)
-// }
+// Error is a failure raised while evaluating an expression. Expr is the
+// sub-expression that failed, Op and Types describe the operator or function
+// call that failed (when there is one), and Trace holds the enclosing
+// applications, innermost first.
+type Error struct {
+	Expr  parser.Expr
+	Op    string
+	Types []string
+	Trace []parser.Expr
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func init() {
+	value.Compile = compile
+}
+
+// compile evaluates the source of a function or operator definition, as
+// saved in a workspace.
+func compile(env *value.Environment, source string) (value.Value, error) {
+	expr, err := parser.NewParser(env).Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	return Eval(env, expr)
+}
+
+func fail(expr parser.Expr, err error) *Error {
+	return &Error{Expr: expr, Err: err}
+}
+
+func failCall(expr parser.Expr, op string, args []value.Value, err error) *Error {
+	types := make([]string, len(args))
+	for i, arg := range args {
+		types[i] = value.Ty(arg).String()
+	}
+	return &Error{Expr: expr, Op: op, Types: types, Err: err}
+}
+
+// trace records expr as an application enclosing the one that failed.
+func trace(expr parser.Expr, err error) error {
+	e, ok := err.(*Error)
+	if !ok {
+		return fail(expr, err)
+	}
+	e.Trace = append(e.Trace, expr)
+	return e
+}
 
 func define(env *value.Environment, def *parser.Op) (value.Value, error) {
 	name, ok := def.Lhs.(*parser.Id)
 	if !ok {
-		return nil, errors.New("invalid identifier")
+		return nil, fail(def.Lhs, errors.New("invalid identifier"))
+	} else if value.IsBuiltin(name.Value) {
+		return nil, fail(def.Lhs, fmt.Errorf("cannot assign to built-in %s", name.Value))
 	}
 
-	value, err := Eval(env, def.Rhs)
+	val, err := Eval(env, def.Rhs)
 	if err != nil {
-		return nil, err
+		return nil, trace(def, err)
+	}
+
+	if value.IsSys(name.Value) {
+		if err := env.SetSys(name.Value, val); err != nil {
+			return nil, fail(def, err)
+		}
+		return val, nil
 	}
 
-	env.SetVal(name.Value, value)
-	return value, nil
+	if fn, ok := val.(*value.Fn); ok {
+		env.SetFn(name.Value, fn)
+	} else {
+		env.SetVal(name.Value, val)
+	}
+	return val, nil
+}
+
+// lambda creates a function out of a lambda expression. The body is
+// evaluated in a new scope extending the one the lambda was defined in, with
+// the arguments bound to ⍺ and ⍵.
+func lambda(env *value.Environment, def *parser.Lambda) *value.Fn {
+	return value.NewFn(def.Argc, def.Src, func(_ *value.Environment, args ...value.Value) (value.Value, error) {
+		scope := env.Extend()
+		if def.Argc == 2 {
+			scope.SetVal("⍺", args[0])
+		}
+		scope.SetVal("⍵", args[len(args)-1])
+		return Eval(scope, def.Body)
+	})
+}
+
+// operator creates and registers an infix operator out of an operator
+// definition. Its body is evaluated in a new scope extending the one the
+// operator was defined in, with the arguments bound to the names given in
+// the definition. Built-in operators cannot be redefined.
+func operator(env *value.Environment, def *parser.OpDef) (*value.Op, error) {
+	if value.IsBuiltin(def.Op) {
+		return nil, fail(def, fmt.Errorf("cannot redefine built-in operator %s", def.Op))
+	}
+
+	op := value.NewOp(def.Src, func(_ *value.Environment, args ...value.Value) (value.Value, error) {
+		scope := env.Extend()
+		scope.SetVal(def.Lhs, args[0])
+		scope.SetVal(def.Rhs, args[1])
+		return Eval(scope, def.Body)
+	})
+	env.SetOp(def.Op, op)
+	return op, nil
+}
+
+// num converts a number literal, which is stored as a machine value when it
+// is neither a rational nor complex.
+func num(lit *parser.Num) *value.Num {
+	if lit.Imag != nil {
+		return value.NewComplex(lit.Value, lit.Imag)
+	} else if lit.Rat != nil {
+		return &value.Num{Value: lit.Value, Rat: lit.Rat}
+	}
+	return value.NewNum(lit.Value)
 }
 
 func Eval(env *value.Environment, expr parser.Expr) (value.Value, error) {
 	switch e := expr.(type) {
 	case *parser.Num:
-		return &value.Num{Value: e.Value}, nil
+		return num(e), nil
+	case *parser.Str:
+		return value.NewStr(e.Value), nil
 	case *parser.Arr:
-		arr := &value.Arr{Values: make([]*value.Num, len(e.Values))}
+		vals := make([]value.Value, len(e.Values))
 		for i, val := range e.Values {
-			arr.Values[i] = &value.Num{Value: val.Value}
+			vals[i] = num(val)
 		}
-		return arr, nil
+		return value.NewArr(vals), nil
 	case *parser.Id:
 		if env.HasVal(e.Value) {
 			return env.GetVal(e.Value), nil
@@ -65,25 +158,66 @@ func Eval(env *value.Environment, expr parser.Expr) (value.Value, error) {
 		if env.HasFn(e.Value) {
 			return env.GetFn(e.Value), nil
 		}
-		return nil, fmt.Errorf("%s is not defined", e.Value)
+		return nil, fail(e, fmt.Errorf("%s is not defined", e.Value))
+	case *parser.Lambda:
+		return lambda(env, e), nil
+	case *parser.OpDef:
+		op, err := operator(env, e)
+		if err != nil {
+			return nil, err
+		}
+		return op, nil
 	case *parser.Group:
+		if e.Sub == nil {
+			return nil, fail(e, errors.New("empty group"))
+		}
 		return Eval(env, e.Sub)
 
 	case *parser.App:
 		if !env.HasFn(e.Op) {
-			return nil, fmt.Errorf("%s is not defined", e.Op)
+			return nil, fail(e, fmt.Errorf("%s is not defined", e.Op))
 		}
 		fn := env.GetFn(e.Op)
 		var args []value.Value
 		for _, arg := range e.Args {
 			val, err := Eval(env, arg)
 			if err != nil {
-				return nil, err
+				return nil, trace(e, err)
 			}
 			args = append(args, val)
 		}
 
-		return fn.Dispatch(env, args...)
+		res, err := fn.Dispatch(env, args...)
+		if err != nil {
+			return nil, failCall(e, e.Op, args, err)
+		}
+		return res, nil
+
+	case *parser.Reduce:
+		var fn value.Callable
+		if env.HasOp(e.Op) {
+			fn = env.GetOp(e.Op)
+		} else if env.HasFn(e.Op) {
+			fn = env.GetFn(e.Op)
+		} else {
+			return nil, fail(e, fmt.Errorf("%s is not defined", e.Op))
+		}
+
+		arg, err := Eval(env, e.Arg)
+		if err != nil {
+			return nil, trace(e, err)
+		}
+
+		var res value.Value
+		if e.Scan {
+			res, err = value.Scan(env, fn, arg)
+		} else {
+			res, err = value.Reduce(env, fn, arg)
+		}
+		if err != nil {
+			return nil, failCall(e, e.Op, []value.Value{arg}, err)
+		}
+		return res, nil
 
 	case *parser.Op:
 		if e.Op == ":=" {
@@ -91,20 +225,24 @@ func Eval(env *value.Environment, expr parser.Expr) (value.Value, error) {
 		}
 
 		if !env.HasOp(e.Op) {
-			return nil, fmt.Errorf("%s is not defined", e.Op)
+			return nil, fail(e, fmt.Errorf("%s is not defined", e.Op))
 		}
 		op := env.GetOp(e.Op)
 		lhs, err := Eval(env, e.Lhs)
 		if err != nil {
-			return nil, err
+			return nil, trace(e, err)
 		}
 		rhs, err := Eval(env, e.Rhs)
 		if err != nil {
-			return nil, err
+			return nil, trace(e, err)
 		}
 
-		return op.Dispatch(env, lhs, rhs)
+		res, err := op.Dispatch(env, lhs, rhs)
+		if err != nil {
+			return nil, failCall(e, e.Op, []value.Value{lhs, rhs}, err)
+		}
+		return res, nil
 	}
 
-	return nil, errors.New("bad expression")
+	return nil, fail(expr, errors.New("bad expression"))
 }
@@ -0,0 +1,380 @@
+package evaluator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/minond/calc/parser"
+	"github.com/minond/calc/value"
+)
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		label  string
+		input  []string
+		output string
+	}{
+		{"monadic lambda", []string{"sq := { ⍵ × ⍵ }", "sq 3"}, "9"},
+		{"dyadic lambda", []string{"hyp := { (⍺ × ⍺) + ⍵ × ⍵ }", "hyp 3 (4)"}, "25"},
+		{"lambda closes over its scope", []string{"k := 2", "scale := { ⍵ × k }", "scale 4"}, "8"},
+		{"lambda arguments are local", []string{"f := { ⍵ }", "f 1", "⍵"}, "⍵ is not defined"},
+		{"user defined operator", []string{"a ± b := (a × a) + b × b", "3 ± 4"}, "25"},
+		{"user defined operator arguments are local", []string{"a ∘ b := a", "1 ∘ 2", "b"}, "b is not defined"},
+		{"redefining a user defined operator", []string{"a ∘ b := a", "a ∘ b := b", "1 ∘ 2"}, "2"},
+		{"redefining a built-in operator", []string{"a + b := a × b"}, "cannot redefine built-in operator +"},
+		{"built-in operators are kept", []string{"a + b := a × b", "2 + 3"}, "5"},
+		{"sum", []string{"+/ 1 2 3 4"}, "10"},
+		{"product", []string{"×/ 1 2 3 4"}, "24"},
+		{"running total", []string{"+\\ 1 2 3 4"}, " 1  3  6 10"},
+		{"reduce with a function", []string{"f := { ⍺ + ⍵ }", "f/ 1 2 3"}, "6"},
+		{"reduce a generator", []string{"+/ ...$ 101"}, "5050"},
+		{"scan a generator", []string{"(+\\ ...$ 10) --- 4"}, "0 1 3 6"},
+		{"reduce an empty array", []string{"+/ 0 .. 0"}, "cannot reduce an empty array"},
+		{"descending range", []string{"⍴ 5 .. 1"}, "0"},
+		{"range past an int64", []string{"¯9e18 .. 9e18"}, "result is too large, with more than 16777216 items"},
+		{"range too large", []string{"0 .. 1e12"}, "result is too large, with more than 16777216 items"},
+		{"until a negative number", []string{"⍴ ... ¯1"}, "0"},
+		{"reduce from the right", []string{"-/ 1 2 3"}, "2"},
+		{"scan from the right", []string{"-\\ 1 2 3 4"}, " 1 -1  2 -2"},
+		{"reduce a generator from the right", []string{"÷/ ...$ 8 4 2"}, "4"},
+		{"scan a generator from the right", []string{"(-\\ ...$ 10) --- 4"}, " 0 -1  1 -2"},
+		{"reduce an infinite generator from the right", []string{"-/ iterate (neg) 1"}, "cannot reduce more than 1048576 items of a generator with a function that is not associative"},
+		{"scan an infinite generator from the right", []string{"(-\\ iterate (neg) 1) --- 2000"}, "cannot scan more than 1024 items of a generator with a function that is not associative"},
+		{"subtraction groups to the left", []string{"10 - 3 - 2"}, "5"},
+		{"division", []string{"7 ÷ 2"}, "3.5"},
+		{"division by zero", []string{"1 ÷ 0"}, "division by zero"},
+		{"zero divided by zero", []string{"0 ÷ 0"}, "1"},
+		{"power groups to the right", []string{"2 * 3 * 2"}, "512"},
+		{"negative power", []string{"2 * (- 2)"}, "0.25"},
+		{"overflowing integer power", []string{"2 * 1e10"}, "result is out of range"},
+		{"overflowing fractional power", []string{"2 * (1e10 + 0.5)"}, "result is out of range"},
+		{"overflowing power of a complex number", []string{"1J1 * 1e10"}, "result is out of range"},
+		{"overflowing power in a larger expression", []string{"(2 * 1e10) × 0"}, "result is out of range"},
+		{"residue", []string{"3 | 10"}, "1"},
+		{"residue of a negative number", []string{"3 | (- 10)"}, "2"},
+		{"minimum", []string{"5 ⌊ 3"}, "3"},
+		{"maximum", []string{"5 ⌈ 3"}, "5"},
+		{"floor and ceiling", []string{"(⌊ 2.5) + ⌈ 2.5"}, "5"},
+		{"negate", []string{"- 3 + 4"}, "-7"},
+		{"comparison mask", []string{"1 2 3 4 ≥ 3"}, "0 0 1 1"},
+		{"comparison of arrays", []string{"1 2 3 = 3 2 1"}, "0 1 0"},
+		{"and", []string{"1 1 0 0 ∧ 1 0 1 0"}, "1 0 0 0"},
+		{"or", []string{"1 1 0 0 ∨ 1 0 1 0"}, "1 1 1 0"},
+		{"not", []string{"~ 1 0 1"}, "0 1 0"},
+		{"greatest common divisor", []string{"12 ∨ 18 ¯8 0"}, " 6  4 12"},
+		{"least common multiple", []string{"4 ∧ 6 0 ¯3"}, "12  0 12"},
+		{"common divisor of non-integers", []string{"1.5 ∨ 3"}, "expecting an integer but got 1.5"},
+		{"pi times", []string{"○ 1 2"}, "3.1415926535897932385  6.283185307179586477"},
+		{"sine and cosine", []string{"1 2 ○ 1"}, "0.84147098480789650666  0.5403023058681397174"},
+		{"inverse circle functions", []string{"¯1 ¯2 ¯3 ○ 1 0.5 1"}, " 1.5707963267948966193  1.0471975511965977461 0.78539816339744830963"},
+		{"hyperbolic functions", []string{"5 6 7 ○ 1"}, "1.1752011936438014569 1.5430806348152437784 0.7615941559557648881"},
+		{"circle function out of domain", []string{"¯1 ○ 2"}, "expecting a number from -1 to 1 but got 2"},
+		{"invalid circle function", []string{"8 ○ 1"}, "expecting a circle function from -12 to 12 but got 8"},
+		{"circle functions at a higher precision", []string{"⎕FPREC := 100", "1 ○ 1"}, "0.84147098480789650665250232163"},
+		{"exponential", []string{"* 1"}, "2.7182818284590452354"},
+		{"natural logarithm", []string{"⍟ 10"}, "2.302585092994045684"},
+		{"logarithm in a base", []string{"10 ⍟ 1000"}, "3"},
+		{"logarithm of zero", []string{"⍟ 0"}, "logarithm of zero"},
+		{"logarithm of a negative number", []string{"⍟ ¯1"}, "0J3.1415926535897932385"},
+		{"pi at a higher precision", []string{"⎕FPREC := 200", "○ 1"}, "3.141592653589793238462643383279502884197169399375105820974944"},
+		{"fractional power at a higher precision", []string{"⎕FPREC := 100", "2 * 0.5"}, "1.414213562373095048801688724209"},
+		{"square root", []string{"sqrt 2"}, "1.4142135623730950488"},
+		{"sqrt of a negative number", []string{"sqrt ¯4"}, "0J2"},
+		{"factorial", []string{"! 0 5"}, "  1 120"},
+		{"exact factorial", []string{"⎕EXACT := 1", "! 25"}, "15511210043330985984000000"},
+		{"factorial of a fraction", []string{"! 1.5"}, "expecting a non-negative integer but got 1.5"},
+		{"binomial", []string{"2 5 ! 5 4"}, "10  0"},
+		{"math functions over generators", []string{"+/ sqrt ...$ 5"}, "6.146264369941972342"},
+		{"encode a matrix as JSON", []string{"json⍞ 2 2 ⍴ 1 .. 5"}, `{"shape":[2,2],"values":[1,2,3,4]}`},
+		{"encode nested arrays as JSON", []string{"json⍞ (⊂ 1 2) , (⊂ 'ab') , 3"}, `[[1,2],"ab",3]`},
+		{"encode a rational as JSON", []string{"⎕EXACT := 1", "json⍞ ÷ 3"}, `{"num":"1/3"}`},
+		{"decode a rational from JSON", []string{"⎕EXACT := 1", "(json⍎ json⍞ ÷ 3) = ÷ 3"}, "1"},
+		{"decode a string of a number from JSON", []string{"⍴ json⍎ json⍞ '12'"}, "2"},
+		{"decode JSON", []string{`json⍎ '{"shape":[2,2],"values":[1,2,3,4]}'`}, "1 2\n  3 4"},
+		{"decode JSON at the precision", []string{"⎕FPREC := 200", "x := ÷ 3", "(json⍎ json⍞ x) = x"}, "1"},
+		{"decode invalid JSON", []string{"json⍎ '[1,'"}, "unexpected EOF"},
+		{"encode a generator as JSON", []string{"json⍞ ...$ 3"}, "function does not implement 1/<generator<number>>"},
+		{"compress", []string{"1 0 1 0 / 5 6 7 8"}, "5 7"},
+		{"replicate", []string{"1 0 2 / 5 6 7"}, "5 7 7"},
+		{"replicate too many times", []string{"1e18 / 1 2 3"}, "result is too large, with more than 16777216 items"},
+		{"replicate a number too many times", []string{"1e15 / 7"}, "result is too large, with more than 16777216 items"},
+		{"replicate items too many times", []string{"1 1e18 / 5 6"}, "result is too large, with more than 16777216 items"},
+		{"filter by a predicate", []string{"x := 1 .. 10", "(x > 6) / x"}, "7 8 9"},
+		{"filter a generator", []string{"(((...$ 10) > 3) / ...$ 10) --- 3"}, "4 5 6"},
+		{"shape of a vector", []string{"⍴ 1 2 3"}, "3"},
+		{"reshape", []string{"2 3 ⍴ 1 .. 7"}, "1 2 3\n  4 5 6"},
+		{"reshape repeats items", []string{"2 2 ⍴ 7"}, "7 7\n  7 7"},
+		{"shape of a matrix", []string{"⍴ 2 3 ⍴ 1"}, "2 3"},
+		{"ravel", []string{", 2 2 ⍴ 1 .. 5"}, "1 2 3 4"},
+		{"catenate", []string{"1 2 , 3"}, "1 2 3"},
+		{"catenate matrices", []string{"m := 2 2 ⍴ 1 .. 5", "m , m"}, "1 2 1 2\n  3 4 3 4"},
+		{"catenate a matrix and a number", []string{"m := 2 2 ⍴ 1 .. 5", "m , 9"}, "1 2 9\n  3 4 9"},
+		{"catenate a number and a matrix", []string{"m := 2 2 ⍴ 1 .. 5", "9 , m"}, "9 1 2\n  9 3 4"},
+		{"catenate a matrix and a vector", []string{"m := 2 2 ⍴ 1 .. 5", "m , 8 9"}, "1 2 8\n  3 4 9"},
+		{"catenate a matrix and a vector of another length", []string{"m := 2 2 ⍴ 1 .. 5", "m , 7 8 9"},
+			"array shapes do not match, left is 2 2 but right is 3"},
+		{"columns are aligned", []string{"2 2 ⍴ 1 100 10 1"}, " 1 100\n  10   1"},
+		{"add a vector to every row", []string{"(2 3 ⍴ 0) + 1 2 3"}, "1 2 3\n  1 2 3"},
+		{"default precision", []string{"1 ÷ 3"}, "0.33333333333333333334"},
+		{"53 bits of precision", []string{"⎕FPREC := 53", "1 ÷ 3"}, "0.3333333333333333"},
+		{"integers beyond an int64", []string{"9223372036854775807 + 1"}, "9223372036854775808"},
+		{"integer overflow is exact", []string{"9223372036854775807 × 3"}, "27670116110564327421"},
+		{"big integers stay exact", []string{"(9223372036854775807 × 3) - 9223372036854775807 × 2"}, "9223372036854775807"},
+		{"big integers at a low precision", []string{"⎕FPREC := 53", "9223372036854775807 + 1"}, "9.223372036854776e+18"},
+		{"integer overflow is promoted", []string{"⎕FPREC := 200", "9223372036854775807 + 1"}, "9223372036854775808"},
+		{"integer products are promoted", []string{"⎕FPREC := 200", "4294967296 × 4294967296"}, "18446744073709551616"},
+		{"integer powers are promoted", []string{"⎕FPREC := 200", "2 * 70"}, "1180591620717411303424"},
+		{"integers are printed in full", []string{"1_000_000"}, "1000000"},
+		{"big integers are printed in full", []string{"2 * 64"}, "18446744073709551616"},
+		{"integer division", []string{"6 ÷ 3 , 7 ÷ 2"}, "  2 3.5"},
+		{"fractional residue", []string{"1.5 | ¯4.25"}, "0.25"},
+		{"higher precision", []string{"⎕FPREC := 200", "1 ÷ 3"}, "0.3333333333333333333333333333333333333333333333333333333333334"},
+		{"literals use the precision", []string{"⎕FPREC := 200", "0.1 + 0.2 - 0.3"}, "0"},
+		{"lower precision", []string{"⎕FPREC := 8", "1 ÷ 3"}, "0.334"},
+		{"rounding toward zero", []string{"⎕FPREC := 8", "⎕RM := 2", "1 ÷ 3"}, "0.332"},
+		{"print precision", []string{"⎕PP := 5", "1 2 ÷ 3"}, "0.33333 0.66667"},
+		{"print precision leaves integers whole", []string{"⎕PP := 3", "123456789 , 1 ÷ 3"}, "123456789     0.333"},
+		{"print precision in nested arrays", []string{"⎕PP := 3", "(⊂ 1 ÷ 3 6) , 2 ÷ 3"}, "(0.333 0.167) 0.667"},
+		{"invalid precision", []string{"⎕FPREC := 0"}, "⎕FPREC must be a number of bits from 1 to 65536 but got 0"},
+		{"precision too high", []string{"⎕FPREC := 65537"}, "⎕FPREC must be a number of bits from 1 to 65536 but got 65537"},
+		{"invalid rounding mode", []string{"⎕RM := 6"}, "⎕RM must be a rounding mode from 0 to 5 but got 6"},
+		{"invalid print precision", []string{"⎕PP := 1.5"}, "⎕PP must be a number of digits from 0 to 1000 but got 1.5"},
+		{"exact division", []string{"⎕EXACT := 1", "1 ÷ 3"}, "1/3"},
+		{"exact sum of fractions", []string{"⎕EXACT := 1", "(1 ÷ 3) + 1 ÷ 6"}, "1/2"},
+		{"exact decimals", []string{"⎕EXACT := 1", "0.1 + 0.2"}, "3/10"},
+		{"exact comparison", []string{"⎕EXACT := 1", "(0.1 + 0.2) = 0.3"}, "1"},
+		{"exact split adds up", []string{"⎕EXACT := 1", "+/ 100 ÷ 3 3 3"}, "100"},
+		{"exact integer power", []string{"⎕EXACT := 1", "(2 ÷ 3) * ¯2"}, "9/4"},
+		{"exact residue", []string{"⎕EXACT := 1", "(1 ÷ 3) | 1.5"}, "1/6"},
+		{"exact floor", []string{"⎕EXACT := 1", "⌊ ¯7 ÷ 2"}, "-4"},
+		{"exact negation", []string{"⎕EXACT := 1", "- 1 ÷ 3"}, "-1/3"},
+		{"fractional power is approximate", []string{"⎕EXACT := 1", "4 * 0.5"}, "2"},
+		{"rounded integers are approximate", []string{"⎕EXACT := 1", "(○ 1e20) ÷ 3"}, "1.04719755119659774616e+20"},
+		{"exact power too large", []string{"⎕EXACT := 1", "2 * 100000000000"}, "result is out of range"},
+		{"fractions printed as decimals", []string{"⎕EXACT := 1", "⎕FRAC := 0", "⎕PP := 5", "1 2 ÷ 3"}, "0.33333 0.66667"},
+		{"complex number", []string{"3j4"}, "3J4"},
+		{"complex addition", []string{"3j4 + 1j¯2"}, "4J2"},
+		{"complex multiplication", []string{"3j4 × 1j2"}, "-5J10"},
+		{"complex division", []string{"¯5j10 ÷ 1j2"}, "3J4"},
+		{"complex integer power", []string{"0j1 * 2"}, "-1"},
+		{"square root of a negative number", []string{"⎕PP := 5", "¯4 * 0.5"}, "1.2246e-16J2"},
+		{"imaginary parts that cancel are real", []string{"3j4 - 0j4"}, "3"},
+		{"mixed real and complex array", []string{"1 2j1 3 + 1"}, "  2 3J1   4"},
+		{"magnitude of a complex number", []string{"| 3j4"}, "5"},
+		{"abs of a complex number", []string{"abs 3j4"}, "5"},
+		{"negation of a complex number", []string{"neg 3j4"}, "-3J-4"},
+		{"real and imaginary parts", []string{"(real 3j4) , imag 3j4"}, "3 4"},
+		{"conjugate", []string{"conjugate 3j4 5"}, "3J-4    5"},
+		{"phase", []string{"⎕PP := 5", "phase 0j1 ¯1"}, "1.5708 3.1416"},
+		{"complex equality", []string{"3j4 = 3j4 3j¯4"}, "1 0"},
+		{"complex numbers have no order", []string{"3j4 < 5"}, "expecting a real number but got 3J4"},
+		{"string", []string{"'hello, world'"}, "hello, world"},
+		{"string with a quote", []string{"'it''s'"}, "it's"},
+		{"length of a string", []string{"len 'hello'"}, "5"},
+		{"character of a string", []string{"1 @ 'hello'"}, "e"},
+		{"characters of a string", []string{"4 1 @ 'hello'"}, "oe"},
+		{"reshape a string", []string{"2 3 ⍴ 'abcdef'"}, "abc\n  def"},
+		{"catenate strings", []string{"x := 'foo'", "x , 'bar'"}, "foobar"},
+		{"catenate a character", []string{"'ab' , 0 @ 'cd'"}, "abc"},
+		{"strings in an array", []string{"(⊂ 'ab') , ⊂ 'cd'"}, "(ab) (cd)"},
+		{"add to a string", []string{"'ab' + 1"}, "expecting numbers but got <char> and <number>"},
+		{"mismatched shapes", []string{"(2 3 ⍴ 0) + 1 2"}, "array shapes do not match, left is 2 3 but right is 2"},
+		{"sum of every row", []string{"+/ 2 3 ⍴ 1 .. 7"}, " 6 15"},
+		{"nested arrays", []string{"(⊂ 1 2) , (⊂ 3 4 5)"}, "(1 2) (3 4 5)"},
+		{"arithmetic on nested arrays", []string{"((⊂ 1 2) , (⊂ 3 4 5)) × 2"}, "(2 4) ( 6  8 10)"},
+		{"index into the result of a generator", []string{"1 2 @ ((3 × ...$ 9999999999999999) --- 100)"}, "3 6"},
+		{"index with a number on either side", []string{"(2 @ 5 6 7) , (5 6 7) @ 0"}, "7 5"},
+		{"index into a generator", []string{"3 @ (10 × ...$ 10)"}, "30"},
+		{"index with a generator", []string{"((...$ 2) @ 5 6 7) --- 5"}, "5 6"},
+		{"add a number to a generator", []string{"((...$ 5) + 1) --- 5"}, "1 2 3 4 5"},
+		{"add two generators", []string{"((...$ 3) + ...$ 10) --- 5"}, "0 2 4"},
+		{"add an array to a generator", []string{"((...$ 3) + (1 2)) --- 2"}, "(1 2) (2 3)"},
+		{"negate a generator", []string{"(- (...$ 3) + 1) --- 3"}, "-1 -2 -3"},
+		{"zip", []string{"(zip (...$ 3) (...$ 10)) --- 5"}, "(0 0) (1 1) (2 2)"},
+		{"filter with a lambda", []string{"(filter {(2 | ⍵) = 0} (...$ 10)) --- 10"}, "0 2 4 6 8"},
+		{"filter with a named function", []string{"odd := {(2 | ⍵) = 1}", "(filter (odd) ...$ 10) --- 10"}, "1 3 5 7 9"},
+		{"filter an array", []string{"filter {⍵ > 2} 1 2 3 4"}, "3 4"},
+		{"take while", []string{"(takewhile {⍵ < 4} ...$ 10) --- 10"}, "0 1 2 3"},
+		{"drop while", []string{"(dropwhile {⍵ < 7} ...$ 10) --- 10"}, "7 8 9"},
+		{"drop", []string{"(drop 8 (...$ 10)) --- 10"}, "8 9"},
+		{"drop from an array", []string{"drop 2 (1 2 3 4)"}, "3 4"},
+		{"iterate", []string{"(iterate {⍵ × 2} 1) --- 4"}, "1 2 4 8"},
+		{"concatenate generators", []string{"((...$ 2) , (...$ 3)) --- 10"}, "0 1 0 1 2"},
+		{"concatenate a generator and an array", []string{"((...$ 2) , 7 8) --- 10"}, "0 1 7 8"},
+		{"reuse a generator", []string{"x := ...$ 10", "x --- 3", "x --- 3"}, "0 1 2"},
+		{"reuse a derived generator", []string{"x := (...$ 10) × 2", "+/ x", "x --- 3"}, "0 2 4"},
+		{"generator used twice in one expression", []string{"x := ...$ 3", "(x + x) --- 3"}, "0 2 4"},
+		{"stream the rows of a matrix", []string{"+/ ...$ 2 3 ⍴ 1 2 3 4 5 6"}, "5 7 9"},
+		{"take rows of a matrix", []string{"(...$ 3 2 ⍴ 1 2 3 4 5 6) --- 2"}, "(1 2) (3 4)"},
+		{"take more than a generator has", []string{"(...$ 5) --- 1e15"}, "0 1 2 3 4"},
+		{"filter rows of a matrix", []string{"(filter {(+/ ⍵) > 5} ...$ 3 2 ⍴ 1 2 3 4 5 6) --- 3"}, "(3 4) (5 6)"},
+		{"mask with a generator of arrays", []string{"(zip (...$ 2) (...$ 2)) / ...$ 2"}, "operator does not implement 2/<generator<array>>/<generator<number>>"},
+		{"failure inside a generator", []string{"(((...$ 3) - 1) ÷ 0) --- 3"}, "division by zero"},
+		{"index rows of a matrix", []string{"0 2 @ (3 2 ⍴ 1 .. 7)"}, "1 2\n  5 6"},
+		{"negative index", []string{"(- 1) @ 5 6"}, "index -1 is negative"},
+		{"index past the end", []string{"2 @ 5 6"}, "index out of bounds, 2 is past the last of 2 items"},
+		{"index origin", []string{"⎕IO := 1", "1 @ 5 6"}, "5"},
+		{"invalid index origin", []string{"⎕IO := 2"}, "⎕IO must be 0 or 1 but got 2"},
+		{"average", []string{"avg := { (+/ ⍵) ÷ len ⍵ }", "avg 1 2 3 4"}, "2.5"},
+		{"redefining a function as a value", []string{"f := { ⍵ }", "f := 3", "f + 1"}, "4"},
+		{"assigning to a built-in function", []string{"abs := 3"}, "cannot assign to built-in abs"},
+		{"built-in functions are kept", []string{"abs := 3", "abs 0 - 2"}, "2"},
+		{"redefining a built-in function", []string{"len := { 0 }", "len 1 2 3"}, "3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			env := value.NewEnvironment()
+			p := parser.NewParser(env)
+
+			var res string
+			for _, input := range test.input {
+				expr, err := p.Parse(input)
+				if err != nil {
+					t.Fatalf("unexpected syntax error in `%s`: %v", input, err)
+				}
+
+				val, err := Eval(env, expr)
+				if err != nil {
+					res = err.Error()
+				} else {
+					res = value.Format(env, val)
+				}
+			}
+
+			if res != test.output {
+				t.Errorf("invalid result:\nexpected: %s\nreturned: %s", test.output, res)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		label string
+		input string
+		expr  string
+		op    string
+		trace []string
+	}{
+		{"undefined identifier", "x", "1:1", "", nil},
+		{"unsupported argument types", "1 + ((...$ 5) @ (...$ 5))", "1:6", "@", []string{"1:1"}},
+		{"failure inside a function argument", "abs (1 + x)", "1:10", "", []string{"1:6", "1:1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			env := value.NewEnvironment()
+			expr, err := parser.NewParser(env).Parse(test.input)
+			if err != nil {
+				t.Fatalf("unexpected syntax error: %v", err)
+			}
+
+			_, err = Eval(env, expr)
+			e, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("expected an *Error but got %v", err)
+			}
+
+			if e.Expr.Span().String() != test.expr {
+				t.Errorf("expected failing expression at %s but got %s", test.expr, e.Expr.Span())
+			}
+			if e.Op != test.op {
+				t.Errorf("expected failing operator `%s` but got `%s`", test.op, e.Op)
+			}
+			if len(e.Trace) != len(test.trace) {
+				t.Fatalf("expected a trace of %d but got %d", len(test.trace), len(e.Trace))
+			}
+			for i, expr := range e.Trace {
+				if expr.Span().String() != test.trace[i] {
+					t.Errorf("expected trace entry %d at %s but got %s", i, test.trace[i], expr.Span())
+				}
+			}
+		})
+	}
+}
+
+func TestWorkspace(t *testing.T) {
+	tests := []struct {
+		label  string
+		saved  []string
+		input  string
+		output string
+	}{
+		{"number", []string{"x := 42"}, "x", "42"},
+		{"precise number", []string{"⎕FPREC := 200", "x := ÷ 3"}, "x = ÷ 3", "1"},
+		{"precise number at a lower precision", []string{"⎕FPREC := 200", "x := ÷ 3", "⎕FPREC := 53"}, "x", "0.3333333333333333333333333333333333333333333333333333333333334"},
+		{"rational", []string{"⎕EXACT := 1", "x := ÷ 3"}, "x", "1/3"},
+		{"complex number", []string{"x := 3J4"}, "x", "3J4"},
+		{"matrix", []string{"m := 2 2 ⍴ 1 .. 5"}, "m", "1 2\n  3 4"},
+		{"string", []string{"s := 'hi'"}, "s", "hi"},
+		{"string of a number", []string{"s := '12'"}, "⍴ s", "2"},
+		{"string of a fraction", []string{"s := '1/3'"}, "⍴ s", "3"},
+		{"system variable", []string{"⎕IO := 1"}, "⎕IO", "1"},
+		{"function", []string{"sq := { ⍵ × ⍵ }"}, "sq 3", "9"},
+		{"functions that refer to each other", []string{"sq := { ⍵ × ⍵ }", "quad := { sq sq ⍵ }"}, "quad 2", "16"},
+		{"operator", []string{"a ± b := (a × a) + b × b"}, "3 ± 4", "25"},
+		{"built-ins are left out", []string{"f := sqrt"}, "f", "f is not defined"},
+		{"generators are left out", []string{"g := ...$ 3"}, "g", "g is not defined"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			env := value.NewEnvironment()
+			for _, input := range test.saved {
+				expr, err := parser.NewParser(env).Parse(input)
+				if err != nil {
+					t.Fatalf("unexpected syntax error in `%s`: %v", input, err)
+				} else if _, err := Eval(env, expr); err != nil {
+					t.Fatalf("unexpected error in `%s`: %v", input, err)
+				}
+			}
+
+			var buff bytes.Buffer
+			if err := env.Save(&buff); err != nil {
+				t.Fatalf("unexpected error saving: %v", err)
+			}
+			loaded := value.NewEnvironment()
+			if err := loaded.Load(&buff); err != nil {
+				t.Fatalf("unexpected error loading: %v", err)
+			}
+
+			var res string
+			expr, err := parser.NewParser(loaded).Parse(test.input)
+			if err != nil {
+				res = err.Error()
+			} else if val, err := Eval(loaded, expr); err != nil {
+				res = err.Error()
+			} else {
+				res = value.Format(loaded, val)
+			}
+
+			if res != test.output {
+				t.Errorf("invalid result:\nexpected: %s\nreturned: %s", test.output, res)
+			}
+		})
+	}
+}
+
+func TestWorkspaceErrors(t *testing.T) {
+	tests := []struct {
+		label string
+		input string
+		err   string
+	}{
+		{"not a workspace", `[1, 2]`, "invalid workspace: json: cannot unmarshal array into Go value of type value.workspace"},
+		{"unsupported version", `{"version": 2}`, "unsupported workspace version 2"},
+		{"invalid system variable", `{"version": 1, "sys": {"⎕IO": 2}}`, "⎕IO must be 0 or 1 but got 2"},
+		{"invalid function", `{"version": 1, "fns": {"f": {"argc": 1, "source": "{ ⍵ +"}}}`, "cannot load f: 1:6: unexpected eof"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			err := value.NewEnvironment().Load(strings.NewReader(test.input))
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expected error %q but got %v", test.err, err)
+			}
+		})
+	}
+}
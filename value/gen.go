@@ -0,0 +1,458 @@
+package value
+
+import (
+	"fmt"
+)
+
+// pull returns the next item of a generator, or false once it is finished.
+type pull func() (Value, bool, error)
+
+// stepper transforms an item of a generator. It returns the new item, or nil
+// to drop it, and ends the generator by setting done.
+type stepper func(Value) (next Value, done bool, err error)
+
+// Gen is a lazy sequence of values of any type. A generator is an immutable
+// description of the sequence rather than a position in it: every call to
+// Iter starts reading it over from the first item, so a generator can be
+// bound to a name and used any number of times. Generators derived from
+// this one compose start, giving each of their iterators its own iterator
+// over this one. The type of the items, when they are all of the same one,
+// is kept in elem so that handlers can be selected by it.
+type Gen struct {
+	elem  ty
+	start func() pull
+}
+
+func newGen(elem ty, start func() pull) *Gen {
+	return &Gen{elem: elem, start: start}
+}
+
+func (g *Gen) Stringify() string {
+	return fmt.Sprintf("generator%s", g.elem)
+}
+
+// Elem returns the type of the items of the generator, which is TUnknown
+// when they may be of different types.
+func (g *Gen) Elem() ty {
+	return g.elem
+}
+
+// Iter starts reading the items of the generator from the first one.
+func (g *Gen) Iter() *Iter {
+	return &Iter{next: g.start()}
+}
+
+// Iter is a position in a generator.
+type Iter struct {
+	next pull
+	done bool
+	err  error
+}
+
+// Next returns the next item, or false once the generator is finished or has
+// failed, in which case Err returns the reason.
+func (it *Iter) Next() (Value, bool) {
+	if it.done {
+		return nil, false
+	}
+
+	val, ok, err := it.next()
+	if err != nil || !ok {
+		it.done = true
+		it.err = err
+		return nil, false
+	}
+	return val, true
+}
+
+// Err returns the error that stopped the iterator, if any.
+func (it *Iter) Err() error {
+	return it.err
+}
+
+// With creates a generator that passes every item of g through step, which
+// keeps the type of the items.
+func (g *Gen) With(step stepper) *Gen {
+	return g.Map(g.elem, step)
+}
+
+// Map is like With for a step that turns items into values of type elem.
+func (g *Gen) Map(elem ty, step stepper) *Gen {
+	return newGen(elem, func() pull {
+		next := g.start()
+		return func() (Value, bool, error) {
+			for {
+				val, ok, err := next()
+				if err != nil || !ok {
+					return nil, false, err
+				}
+
+				res, done, err := step(val)
+				if err != nil || done {
+					return nil, false, err
+				} else if res != nil {
+					return res, true, nil
+				}
+			}
+		}
+	})
+}
+
+// emptyGen is a generator with no items.
+func emptyGen(elem ty) *Gen {
+	return newGen(elem, func() pull {
+		return func() (Value, bool, error) {
+			return nil, false, nil
+		}
+	})
+}
+
+// toGen turns an array into a generator of its items, or major cells, and
+// returns generators as they are.
+func toGen(val Value) *Gen {
+	switch v := val.(type) {
+	case *Gen:
+		return v
+	case *Arr:
+		n, elem := 1, TArr
+		if v.Rank() > 0 {
+			n = v.Dims()[0]
+		}
+		if v.Rank() == 1 {
+			elem = elemTy(v.Items())
+		}
+		return newGen(elem, func() pull {
+			i := 0
+			return func() (Value, bool, error) {
+				if i >= n {
+					return nil, false, nil
+				}
+				i++
+				return cell(v, i-1), true, nil
+			}
+		})
+	}
+
+	return newGen(Ty(val), func() pull {
+		done := false
+		return func() (Value, bool, error) {
+			if done {
+				return nil, false, nil
+			}
+			done = true
+			return val, true, nil
+		}
+	})
+}
+
+// zipWith pairs up the items of a and b, combining each pair with fn, and
+// finishes as soon as either one does. A nil result from fn drops the pair.
+func zipWith(elem ty, a, b *Gen, fn func(Value, Value) (Value, error)) *Gen {
+	return newGen(elem, func() pull {
+		left, right := a.start(), b.start()
+		return func() (Value, bool, error) {
+			for {
+				lhs, ok, err := left()
+				if err != nil || !ok {
+					return nil, false, err
+				}
+				rhs, ok, err := right()
+				if err != nil || !ok {
+					return nil, false, err
+				}
+
+				res, err := fn(lhs, rhs)
+				if err != nil {
+					return nil, false, err
+				} else if res != nil {
+					return res, true, nil
+				}
+			}
+		}
+	})
+}
+
+// filter keeps the items pred holds for.
+func (g *Gen) filter(pred func(Value) (bool, error)) *Gen {
+	return g.With(func(val Value) (Value, bool, error) {
+		keep, err := pred(val)
+		if err != nil || !keep {
+			return nil, false, err
+		}
+		return val, false, nil
+	})
+}
+
+// takeWhile ends the generator at the first item pred does not hold for.
+func (g *Gen) takeWhile(pred func(Value) (bool, error)) *Gen {
+	return g.With(func(val Value) (Value, bool, error) {
+		keep, err := pred(val)
+		if err != nil || !keep {
+			return nil, true, err
+		}
+		return val, false, nil
+	})
+}
+
+// dropWhile skips items until the first one pred does not hold for.
+func (g *Gen) dropWhile(pred func(Value) (bool, error)) *Gen {
+	return newGen(g.elem, func() pull {
+		next := g.start()
+		dropping := true
+		return func() (Value, bool, error) {
+			for {
+				val, ok, err := next()
+				if err != nil || !ok || !dropping {
+					return val, ok, err
+				}
+
+				drop, err := pred(val)
+				if err != nil {
+					return nil, false, err
+				} else if !drop {
+					dropping = false
+					return val, true, nil
+				}
+			}
+		}
+	})
+}
+
+// drop skips the first n items.
+func (g *Gen) drop(n int) *Gen {
+	return newGen(g.elem, func() pull {
+		next := g.start()
+		skipped := 0
+		return func() (Value, bool, error) {
+			for ; skipped < n; skipped++ {
+				if _, ok, err := next(); err != nil || !ok {
+					return nil, false, err
+				}
+			}
+			return next()
+		}
+	})
+}
+
+// scan produces the running results of folding fn over the items of g.
+func (g *Gen) scan(fn func(Value, Value) (Value, error)) *Gen {
+	return newGen(TUnknown, func() pull {
+		next := g.start()
+		var acc Value
+		return func() (Value, bool, error) {
+			val, ok, err := next()
+			if err != nil || !ok {
+				return nil, false, err
+			}
+
+			if acc == nil {
+				acc = val
+			} else if acc, err = fn(acc, val); err != nil {
+				return nil, false, err
+			}
+			return acc, true, nil
+		}
+	})
+}
+
+// iterate is the infinite generator of x, fn(x), fn(fn(x)), and so on. Since
+// fn may return anything, the type of the items is unknown.
+func iterate(x Value, fn func(Value) (Value, error)) *Gen {
+	return newGen(TUnknown, func() pull {
+		curr := x
+		first := true
+		return func() (Value, bool, error) {
+			if first {
+				first = false
+				return curr, true, nil
+			}
+
+			next, err := fn(curr)
+			if err != nil {
+				return nil, false, err
+			}
+			curr = next
+			return curr, true, nil
+		}
+	})
+}
+
+// concat goes through the items of every generator in turn.
+func concat(gens ...*Gen) *Gen {
+	elem := TUnknown
+	if len(gens) > 0 {
+		elem = gens[0].elem
+	}
+	for _, g := range gens {
+		if g.elem != elem {
+			elem = TUnknown
+		}
+	}
+
+	return newGen(elem, func() pull {
+		i := 0
+		var next pull
+		return func() (Value, bool, error) {
+			for i < len(gens) {
+				if next == nil {
+					next = gens[i].start()
+				}
+
+				val, ok, err := next()
+				if err != nil {
+					return nil, false, err
+				} else if ok {
+					return val, true, nil
+				}
+
+				i++
+				next = nil
+			}
+			return nil, false, nil
+		}
+	})
+}
+
+// predicate wraps a monadic function that returns 0 or 1.
+func predicate(env *Environment, fn *Fn) (func(Value) (bool, error), error) {
+	if fn.Argc != 1 {
+		return nil, fmt.Errorf("expecting a function of 1 argument but got %d", fn.Argc)
+	}
+	return func(val Value) (bool, error) {
+		res, err := fn.Dispatch(env, val)
+		if err != nil {
+			return false, err
+		}
+		num, ok := res.(*Num)
+		if !ok {
+			return false, fmt.Errorf("expecting a boolean but got %s", Ty(res))
+		}
+		return truth(num)
+	}, nil
+}
+
+// collect reads every item of a generator built from an array back into an
+// array.
+func collect(gen *Gen) (*Arr, error) {
+	res := &Arr{Values: []Value{}}
+	it := gen.Iter()
+	for {
+		val, ok := it.Next()
+		if !ok {
+			break
+		}
+		res.Values = append(res.Values, val)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// lazily builds a handler that applies a generator combinator to its last
+// argument, which is an array or generator. Arrays come back as arrays.
+func lazily(combinator func(*Environment, []Value, *Gen) (*Gen, error)) handler {
+	return func(env *Environment, vals ...Value) (Value, error) {
+		last := vals[len(vals)-1]
+		res, err := combinator(env, vals[:len(vals)-1], toGen(last))
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := last.(*Arr); ok {
+			return collect(res)
+		}
+		return res, nil
+	}
+}
+
+// withPredicate builds a handler for a combinator that takes a predicate
+// function as its first argument.
+func withPredicate(combinator func(*Gen, func(Value) (bool, error)) *Gen) handler {
+	return lazily(func(env *Environment, args []Value, gen *Gen) (*Gen, error) {
+		pred, err := predicate(env, args[0].(*Fn))
+		if err != nil {
+			return nil, err
+		}
+		return combinator(gen, pred), nil
+	})
+}
+
+var zip = &Fn{
+	Argc: 2,
+	Impl: fntable{
+		sig(TGen, TGen): zipPairs,
+		sig(TArr, TGen): zipPairs,
+		sig(TGen, TArr): zipPairs,
+	},
+}
+
+func zipPairs(env *Environment, vals ...Value) (Value, error) {
+	return zipWith(TArr, toGen(vals[0]), toGen(vals[1]), func(lhs, rhs Value) (Value, error) {
+		return &Arr{Values: []Value{lhs, rhs}}, nil
+	}), nil
+}
+
+var filter = &Fn{
+	Argc: 2,
+	Impl: fntable{
+		sig(TFn, TGen): withPredicate((*Gen).filter),
+		sig(TFn, TArr): withPredicate((*Gen).filter),
+	},
+}
+
+var takeWhile = &Fn{
+	Argc: 2,
+	Impl: fntable{
+		sig(TFn, TGen): withPredicate((*Gen).takeWhile),
+		sig(TFn, TArr): withPredicate((*Gen).takeWhile),
+	},
+}
+
+var dropWhile = &Fn{
+	Argc: 2,
+	Impl: fntable{
+		sig(TFn, TGen): withPredicate((*Gen).dropWhile),
+		sig(TFn, TArr): withPredicate((*Gen).dropWhile),
+	},
+}
+
+func dropN(env *Environment, args []Value, gen *Gen) (*Gen, error) {
+	n, err := count(args[0].(*Num))
+	if err != nil {
+		return nil, err
+	}
+	return gen.drop(n), nil
+}
+
+var drop = &Fn{
+	Argc: 2,
+	Impl: fntable{
+		sig(TNum, TGen): lazily(dropN),
+		sig(TNum, TArr): lazily(dropN),
+	},
+}
+
+func iterateFn(env *Environment, vals ...Value) (Value, error) {
+	fn := vals[0].(*Fn)
+	if fn.Argc != 1 {
+		return nil, fmt.Errorf("expecting a function of 1 argument but got %d", fn.Argc)
+	}
+	return iterate(vals[1], func(val Value) (Value, error) {
+		return fn.Dispatch(env, val)
+	}), nil
+}
+
+var iterate_ = &Fn{
+	Argc: 2,
+	Impl: fntable{
+		sig(TFn, TNum): iterateFn,
+		sig(TFn, TArr): iterateFn,
+		sig(TFn, TGen): iterateFn,
+	},
+}
+
+// concatenate joins generators, or a generator and an array or number, into
+// a single generator.
+func concatenate(env *Environment, vals ...Value) (Value, error) {
+	return concat(toGen(vals[0]), toGen(vals[1])), nil
+}
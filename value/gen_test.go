@@ -0,0 +1,340 @@
+package value
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func num(f float64) *Num {
+	return NewNum(big.NewFloat(f))
+}
+
+func counter(t *testing.T, n float64) *Gen {
+	t.Helper()
+	val, err := g_until.Dispatch(NewEnvironment(), num(n))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return val.(*Gen)
+}
+
+func apply(fn func(*Num) *Num) stepper {
+	return func(val Value) (Value, bool, error) {
+		return fn(val.(*Num)), false, nil
+	}
+}
+
+func drain(it *Iter, limit int) []string {
+	var vals []string
+	for i := 0; i < limit; i++ {
+		val, ok := it.Next()
+		if !ok {
+			break
+		}
+		vals = append(vals, stringifyItem(val))
+	}
+	return vals
+}
+
+func TestGenNext(t *testing.T) {
+	tests := []struct {
+		label  string
+		gen    func(t *testing.T) *Gen
+		output string
+	}{
+		{"counts up to its limit", func(t *testing.T) *Gen {
+			return counter(t, 5)
+		}, "0 1 2 3 4"},
+		{"empty", func(t *testing.T) *Gen {
+			return counter(t, 0)
+		}, ""},
+		{"single step", func(t *testing.T) *Gen {
+			return counter(t, 3).With(apply(func(n *Num) *Num {
+				return &Num{Value: big.NewFloat(0).Add(n.Float(), one)}
+			}))
+		}, "1 2 3"},
+		{"chained steps run in order", func(t *testing.T) *Gen {
+			return counter(t, 3).
+				With(apply(func(n *Num) *Num {
+					return &Num{Value: big.NewFloat(0).Add(n.Float(), one)}
+				})).
+				With(apply(func(n *Num) *Num {
+					return &Num{Value: big.NewFloat(0).Mul(n.Float(), big.NewFloat(10))}
+				}))
+		}, "10 20 30"},
+		{"steps can drop values", func(t *testing.T) *Gen {
+			return counter(t, 6).With(func(val Value) (Value, bool, error) {
+				n, _ := val.(*Num).Float().Int64()
+				if n%2 == 1 {
+					return nil, false, nil
+				}
+				return val, false, nil
+			})
+		}, "0 2 4"},
+		{"steps can end the generator", func(t *testing.T) *Gen {
+			return counter(t, 100).With(func(val Value) (Value, bool, error) {
+				if val.(*Num).Float().Cmp(big.NewFloat(3)) == 0 {
+					return nil, true, nil
+				}
+				return val, false, nil
+			})
+		}, "0 1 2"},
+		{"values that are not numbers", func(t *testing.T) *Gen {
+			return iterate(&Arr{Values: []Value{}}, func(val Value) (Value, error) {
+				arr := val.(*Arr)
+				return &Arr{Values: append(append([]Value{}, arr.Values...), num(float64(len(arr.Values))))}, nil
+			}).takeWhile(func(val Value) (bool, error) {
+				return len(val.(*Arr).Values) < 3, nil
+			})
+		}, "() (0) (0 1)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			it := test.gen(t).Iter()
+			res := strings.Join(drain(it, 100), " ")
+			if res != test.output {
+				t.Errorf("invalid values:\nexpected: %s\nreturned: %s", test.output, res)
+			}
+			if err := it.Err(); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if _, ok := it.Next(); ok {
+				t.Errorf("expected generator to stay finished")
+			}
+		})
+	}
+}
+
+func TestGenTake(t *testing.T) {
+	tests := []struct {
+		label  string
+		gen    func(t *testing.T) *Gen
+		n      float64
+		output string
+		err    string
+	}{
+		{"fewer items than available", func(t *testing.T) *Gen {
+			return counter(t, 10)
+		}, 3, "0 1 2", ""},
+		{"exactly the items available", func(t *testing.T) *Gen {
+			return counter(t, 3)
+		}, 3, "0 1 2", ""},
+		{"generator finishes early", func(t *testing.T) *Gen {
+			return counter(t, 2)
+		}, 5, "0 1", ""},
+		{"nothing", func(t *testing.T) *Gen {
+			return counter(t, 2)
+		}, 0, "", ""},
+		{"after a step", func(t *testing.T) *Gen {
+			return counter(t, 9999999999999999).With(apply(func(n *Num) *Num {
+				return &Num{Value: big.NewFloat(0).Mul(n.Float(), big.NewFloat(3))}
+			}))
+		}, 4, "0 3 6 9", ""},
+		{"negative count", func(t *testing.T) *Gen {
+			return counter(t, 2)
+		}, -1, "", "expecting a non-negative integer but got -1"},
+		{"failing step", func(t *testing.T) *Gen {
+			return counter(t, 100).With(func(val Value) (Value, bool, error) {
+				if val.(*Num).Float().Cmp(big.NewFloat(2)) == 0 {
+					return nil, false, errors.New("cannot step 2")
+				}
+				return val, false, nil
+			})
+		}, 5, "", "cannot step 2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			val, err := g_take.Dispatch(NewEnvironment(), test.gen(t), num(test.n))
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error `%s` but got %v", test.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if res := val.Stringify(); res != test.output {
+				t.Errorf("invalid values:\nexpected: %s\nreturned: %s", test.output, res)
+			}
+		})
+	}
+}
+
+func TestGenCombinators(t *testing.T) {
+	even := func(val Value) (bool, error) {
+		n, _ := val.(*Num).Float().Int64()
+		return n%2 == 0, nil
+	}
+	below := func(n float64) func(Value) (bool, error) {
+		return func(val Value) (bool, error) {
+			return val.(*Num).Float().Cmp(big.NewFloat(n)) < 0, nil
+		}
+	}
+	sum := func(lhs, rhs Value) (Value, error) {
+		return &Num{Value: big.NewFloat(0).Add(lhs.(*Num).Float(), rhs.(*Num).Float())}, nil
+	}
+
+	tests := []struct {
+		label  string
+		gen    func(t *testing.T) *Gen
+		output string
+		err    string
+	}{
+		{"zip stops at the shortest", func(t *testing.T) *Gen {
+			return zipWith(TNum, counter(t, 3), counter(t, 10), sum)
+		}, "0 2 4", ""},
+		{"zip of a generator with itself", func(t *testing.T) *Gen {
+			gen := counter(t, 3)
+			return zipWith(TNum, gen, gen, sum)
+		}, "0 2 4", ""},
+		{"filter", func(t *testing.T) *Gen {
+			return counter(t, 7).filter(even)
+		}, "0 2 4 6", ""},
+		{"take while", func(t *testing.T) *Gen {
+			return counter(t, 100).takeWhile(below(4))
+		}, "0 1 2 3", ""},
+		{"drop while", func(t *testing.T) *Gen {
+			return counter(t, 6).dropWhile(below(4))
+		}, "4 5", ""},
+		{"drop while keeps later matches", func(t *testing.T) *Gen {
+			return counter(t, 5).dropWhile(even)
+		}, "1 2 3 4", ""},
+		{"drop", func(t *testing.T) *Gen {
+			return counter(t, 5).drop(2)
+		}, "2 3 4", ""},
+		{"drop past the end", func(t *testing.T) *Gen {
+			return counter(t, 2).drop(5)
+		}, "", ""},
+		{"scan", func(t *testing.T) *Gen {
+			return counter(t, 5).scan(sum)
+		}, "0 1 3 6 10", ""},
+		{"iterate", func(t *testing.T) *Gen {
+			return iterate(num(1), func(val Value) (Value, error) {
+				return sum(val, val)
+			}).takeWhile(below(100))
+		}, "1 2 4 8 16 32 64", ""},
+		{"concat", func(t *testing.T) *Gen {
+			return concat(counter(t, 2), emptyGen(TNum), toGen(&Arr{Values: []Value{num(7), num(8)}}), toGen(num(9)))
+		}, "0 1 7 8 9", ""},
+		{"errors are kept", func(t *testing.T) *Gen {
+			return counter(t, 5).filter(func(val Value) (bool, error) {
+				if val.(*Num).Float().Cmp(big.NewFloat(2)) == 0 {
+					return false, errors.New("failed")
+				}
+				return true, nil
+			})
+		}, "0 1", "failed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			it := test.gen(t).Iter()
+			res := strings.Join(drain(it, 100), " ")
+			if res != test.output {
+				t.Errorf("invalid values:\nexpected: %s\nreturned: %s", test.output, res)
+			}
+
+			err := it.Err()
+			if test.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("expected error `%s` but got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestGenRestart(t *testing.T) {
+	gen := counter(t, 5)
+	first := gen.Iter()
+	drain(first, 2)
+
+	if res := strings.Join(drain(gen.Iter(), 100), " "); res != "0 1 2 3 4" {
+		t.Errorf("expected a new iterator to start over but got %s", res)
+	}
+
+	derived := gen.With(apply(func(n *Num) *Num { return n }))
+	if res := strings.Join(drain(derived.Iter(), 100), " "); res != "0 1 2 3 4" {
+		t.Errorf("expected derived generator to start over but got %s", res)
+	}
+	if res := strings.Join(drain(first, 100), " "); res != "2 3 4" {
+		t.Errorf("expected iterator to keep its position but got %s", res)
+	}
+}
+
+func TestGenElem(t *testing.T) {
+	tests := []struct {
+		label string
+		gen   func(t *testing.T) *Gen
+		elem  ty
+	}{
+		{"counter", func(t *testing.T) *Gen {
+			return counter(t, 3)
+		}, TNum},
+		{"vector of numbers", func(t *testing.T) *Gen {
+			return toGen(&Arr{Values: []Value{num(1), num(2)}})
+		}, TNum},
+		{"vector of mixed items", func(t *testing.T) *Gen {
+			return toGen(&Arr{Values: []Value{num(1), &Arr{Values: []Value{num(2)}}}})
+		}, TUnknown},
+		{"rows of a matrix", func(t *testing.T) *Gen {
+			return toGen(&Arr{Shape: []int{2, 2}, Values: []Value{num(1), num(2), num(3), num(4)}})
+		}, TArr},
+		{"zip", func(t *testing.T) *Gen {
+			val, _ := zip.Dispatch(NewEnvironment(), counter(t, 3), counter(t, 3))
+			return val.(*Gen)
+		}, TArr},
+		{"number added to numbers", func(t *testing.T) *Gen {
+			val, _ := add.Dispatch(NewEnvironment(), counter(t, 3), num(1))
+			return val.(*Gen)
+		}, TNum},
+		{"array added to numbers", func(t *testing.T) *Gen {
+			val, _ := add.Dispatch(NewEnvironment(), &Arr{Values: []Value{num(1)}}, counter(t, 3))
+			return val.(*Gen)
+		}, TArr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			if elem := test.gen(t).Elem(); elem != test.elem {
+				t.Errorf("invalid item type:\nexpected: %s\nreturned: %s", test.elem, elem)
+			}
+		})
+	}
+}
+
+func TestGenDispatch(t *testing.T) {
+	fns := toGen(&Arr{Values: []Value{abs, neg}})
+	rows := toGen(&Arr{Shape: []int{2, 1}, Values: []Value{num(1), num(0)}})
+
+	tests := []struct {
+		label string
+		op    *Op
+		vals  []Value
+		err   string
+	}{
+		{"numbers", add, []Value{counter(t, 3), num(1)}, ""},
+		{"functions", add, []Value{fns, num(1)},
+			"operator does not implement 2/<generator<function>>/<number>"},
+		{"mask of numbers", compress, []Value{counter(t, 3), counter(t, 3)}, ""},
+		{"mask of arrays", compress, []Value{rows, counter(t, 3)},
+			"operator does not implement 2/<generator<array>>/<generator<number>>"},
+		{"items of any type", catenate_, []Value{fns, rows}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			_, err := test.op.Dispatch(NewEnvironment(), test.vals...)
+			if test.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("expected error `%s` but got %v", test.err, err)
+			}
+		})
+	}
+}
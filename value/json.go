@@ -0,0 +1,371 @@
+package value
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Values are encoded as JSON at full precision:
+//
+//   - Real numbers are JSON numbers with every digit they need to be read
+//     back exactly, however many that is. Rationals, complex numbers and
+//     infinities, which JSON numbers cannot hold, are objects with their
+//     printed form, such as {"num":"1/3"}, {"num":"3J4"} and {"num":"+Inf"}.
+//   - Strings are JSON strings, and other vectors are lists of their items,
+//     which may be arrays themselves.
+//   - Arrays of any other rank are objects with their shape and their items
+//     in row-major order, such as {"shape":[2,2],"values":[1,2,3,4]}. The
+//     items of a character array are a single string.
+//
+// Decoding reverses this, and also reads true and false as 1 and 0. JSON
+// strings are always read back as strings, even when they hold a number.
+// Since strings and single characters are encoded alike, characters are
+// only read back as such by Char.UnmarshalJSON.
+
+// jsonNum is the JSON form of a number that is not a JSON number.
+type jsonNum struct {
+	Num string `json:"num"`
+}
+
+// jsonArr is the JSON form of an array that is not a vector.
+type jsonArr struct {
+	Shape  []int           `json:"shape"`
+	Values json.RawMessage `json:"values"`
+}
+
+func (n *Num) MarshalJSON() ([]byte, error) {
+	switch n.kind {
+	case kindInt:
+		return []byte(strconv.FormatInt(n.i, 10)), nil
+	case kindBigInt:
+		return []byte(n.b.String()), nil
+	}
+	text := n.Stringify()
+	if n.complex() || n.Rat != nil && !n.Rat.IsInt() || n.kind == kindBig && n.Value.IsInf() {
+		return json.Marshal(jsonNum{Num: text})
+	}
+	return []byte(text), nil
+}
+
+func (n *Num) UnmarshalJSON(data []byte) error {
+	val, err := DecodeJSON(data)
+	if err != nil {
+		return err
+	}
+
+	num, ok := val.(*Num)
+	if !ok {
+		return fmt.Errorf("expecting a number but got %s", val.Stringify())
+	}
+	*n = *num
+	return nil
+}
+
+func (c *Char) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(c.Value))
+}
+
+func (c *Char) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+
+	runes := []rune(text)
+	if len(runes) != 1 {
+		return fmt.Errorf("expecting a single character but got %q", text)
+	}
+	c.Value = runes[0]
+	return nil
+}
+
+func (a *Arr) MarshalJSON() ([]byte, error) {
+	if a.Rank() == 1 {
+		if a.isStr() {
+			return json.Marshal(a.text())
+		}
+		return marshalItems(a.Items())
+	}
+
+	values, err := marshalItems(a.Items())
+	if a.isStr() {
+		values, err = json.Marshal(a.text())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonArr{Shape: a.Dims(), Values: values})
+}
+
+// marshalItems encodes vals as a JSON list.
+func marshalItems(vals []Value) ([]byte, error) {
+	items := make([]json.RawMessage, len(vals))
+	for i, val := range vals {
+		item, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return json.Marshal(items)
+}
+
+// text returns the characters of an array that isStr as a string.
+func (a *Arr) text() string {
+	var b strings.Builder
+	for _, val := range a.Values {
+		b.WriteRune(val.(*Char).Value)
+	}
+	return b.String()
+}
+
+func (a *Arr) UnmarshalJSON(data []byte) error {
+	val, err := DecodeJSON(data)
+	if err != nil {
+		return err
+	}
+
+	arr, ok := val.(*Arr)
+	if !ok {
+		return fmt.Errorf("expecting an array but got %s", val.Stringify())
+	}
+	*a = *arr
+	return nil
+}
+
+func (g *Gen) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("cannot encode a generator as JSON")
+}
+
+func (fn *Fn) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("cannot encode a function as JSON")
+}
+
+func (op *Op) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("cannot encode an operator as JSON")
+}
+
+// EncodeJSON writes val as JSON. It is json.Marshal with the errors of
+// values that cannot be encoded, such as functions, left unwrapped.
+func EncodeJSON(val Value) ([]byte, error) {
+	data, err := json.Marshal(val)
+	for {
+		merr, ok := err.(*json.MarshalerError)
+		if !ok {
+			return data, err
+		}
+		err = merr.Err
+	}
+}
+
+// DecodeJSON reads a value out of its JSON encoding. Numbers are read with
+// as many bits as their digits call for, so that none of them are lost.
+func DecodeJSON(data []byte) (Value, error) {
+	return decodeJSON(data, 0)
+}
+
+// decodeJSON reads a value out of its JSON encoding, with numbers that are
+// not integers rounded to prec bits, or to as many as their digits call for
+// when prec is 0.
+func decodeJSON(data []byte, prec uint) (Value, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var raw interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	} else if dec.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return decode(raw, prec)
+}
+
+// decode converts a value decoded by encoding/json, with numbers kept as
+// json.Number, into a Value.
+func decode(raw interface{}, prec uint) (Value, error) {
+	switch v := raw.(type) {
+	case json.Number:
+		return parseNum(string(v), prec)
+	case bool:
+		if v {
+			return NewInt(1), nil
+		}
+		return NewInt(0), nil
+	case string:
+		return NewStr(v), nil
+	case []interface{}:
+		return decodeItems(v, prec)
+	case map[string]interface{}:
+		if text, ok := v["num"].(string); ok && len(v) == 1 {
+			return parseNum(text, prec)
+		}
+		return decodeShaped(v, prec)
+	}
+	return nil, fmt.Errorf("cannot decode %v from JSON", raw)
+}
+
+func decodeItems(raw []interface{}, prec uint) (*Arr, error) {
+	arr := &Arr{Values: make([]Value, len(raw))}
+	for i, item := range raw {
+		val, err := decode(item, prec)
+		if err != nil {
+			return nil, err
+		}
+		arr.Values[i] = val
+	}
+	return pack(arr), nil
+}
+
+// decodeShaped reads an array that is not a vector, whose items are a list
+// or, for character arrays, a string.
+func decodeShaped(raw map[string]interface{}, prec uint) (*Arr, error) {
+	dims, ok := raw["shape"].([]interface{})
+	if !ok || len(raw) != 2 {
+		return nil, errors.New("expecting a number or an object with a shape and values")
+	}
+
+	shape := make([]int, len(dims))
+	size := 1
+	for i, dim := range dims {
+		n, err := strconv.Atoi(fmt.Sprint(dim))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid shape %v", dim)
+		}
+		shape[i] = n
+		size *= n
+	}
+
+	var arr *Arr
+	switch values := raw["values"].(type) {
+	case string:
+		arr = NewStr(values)
+	case []interface{}:
+		var err error
+		if arr, err = decodeItems(values, prec); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("expecting values to be a list or a string")
+	}
+
+	if arr.Len() != size {
+		return nil, fmt.Errorf("expecting %d values for a shape of %v but got %d", size, shape, arr.Len())
+	}
+	arr.Shape = shape
+	return arr, nil
+}
+
+// parseNum reads a number in any of the forms that Stringify prints them
+// in, which is how JSON and CSV files hold them, at the precision that
+// parseReal reads it at.
+func parseNum(text string, prec uint) (*Num, error) {
+	if i := strings.IndexAny(text, "jJ"); i != -1 {
+		re, err := parseReal(text[:i], prec)
+		if err != nil {
+			return nil, err
+		}
+		im, err := parseReal(text[i+1:], prec)
+		if err != nil {
+			return nil, err
+		}
+		return NewComplex(re.Float(), im.Float()), nil
+	} else if strings.Contains(text, "/") {
+		r, ok := new(big.Rat).SetString(text)
+		if !ok {
+			return nil, fmt.Errorf("invalid number %s", text)
+		}
+		if prec == 0 {
+			prec = float64Prec
+		}
+		return &Num{Value: new(big.Float).SetPrec(prec).SetRat(r), Rat: r}, nil
+	}
+	return parseReal(text, prec)
+}
+
+// maxFloat64Digits is the most significant digits it takes to tell any two
+// float64 values apart.
+const maxFloat64Digits = 17
+
+// parseReal reads a real number, as a machine value when it fits one and as
+// a big.Float otherwise. Integers keep every digit, while other numbers are
+// rounded to prec bits, or to as many as their digits call for when prec is
+// 0.
+func parseReal(text string, prec uint) (*Num, error) {
+	if text == "+Inf" || text == "-Inf" {
+		return NewNum(new(big.Float).SetInf(text[0] == '-')), nil
+	} else if text == "" || text[0] != '-' && (text[0] < '0' || text[0] > '9') || !json.Valid([]byte(text)) {
+		return nil, fmt.Errorf("invalid number %q", text)
+	}
+
+	if i, ok := new(big.Int).SetString(text, 10); ok {
+		return newInteger(i), nil
+	}
+
+	if digits := significant(text); prec == 0 && digits <= maxFloat64Digits {
+		prec = float64Prec
+	} else if prec == 0 {
+		prec = uint(math.Ceil(float64(digits) * math.Log2(10)))
+	}
+	if prec == float64Prec {
+		if f, err := strconv.ParseFloat(text, 64); err == nil && normal(f) {
+			return newFloat64(f), nil
+		}
+	}
+	f, _, err := big.ParseFloat(text, 10, prec, big.ToNearestEven)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", text)
+	}
+	return NewNum(f), nil
+}
+
+// significant counts the digits of the mantissa of a decimal number,
+// leaving out leading zeros.
+func significant(text string) int {
+	if i := strings.IndexAny(text, "eE"); i != -1 {
+		text = text[:i]
+	}
+
+	var n int
+	for _, r := range text {
+		if r >= '1' && r <= '9' || r == '0' && n > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+var jsonEncode = &Fn{
+	Argc: 1,
+	Impl: fntable{
+		sig(TNum):  encodeJSON,
+		sig(TChar): encodeJSON,
+		sig(TArr):  encodeJSON,
+	},
+}
+
+func encodeJSON(env *Environment, vals ...Value) (Value, error) {
+	data, err := EncodeJSON(vals[0])
+	if err != nil {
+		return nil, err
+	}
+	return NewStr(string(data)), nil
+}
+
+var jsonDecode = &Fn{
+	Argc: 1,
+	Impl: fntable{
+		sig(TArr): func(env *Environment, vals ...Value) (Value, error) {
+			arr := vals[0].(*Arr)
+			if !arr.isStr() {
+				return nil, fmt.Errorf("expecting JSON text but got %s", arr.Stringify())
+			}
+			return decodeJSON([]byte(arr.text()), env.Precision())
+		},
+	},
+}
@@ -0,0 +1,103 @@
+package value
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	third := new(big.Float).SetPrec(200).Quo(big.NewFloat(1), big.NewFloat(3))
+	huge := new(big.Float).SetPrec(100).SetInt(new(big.Int).Lsh(big.NewInt(1), 100))
+
+	tests := []struct {
+		label string
+		val   Value
+		json  string
+	}{
+		{"integer", NewInt(42), `42`},
+		{"integer with many digits", NewInt(123456789012345), `123456789012345`},
+		{"float", newFloat64(0.1), `0.1`},
+		{"big integer", NewNum(huge), `1.267650600228229401496703205376e+30`},
+		{"integer beyond an int64", newInteger(new(big.Int).Lsh(big.NewInt(1), 70)), `1180591620717411303424`},
+		{"precise float", NewNum(third), `0.3333333333333333333333333333333333333333333333333333333333334`},
+		{"rational", &Num{Value: big.NewFloat(0.5), Rat: big.NewRat(1, 3)}, `{"num":"1/3"}`},
+		{"complex", NewComplex(big.NewFloat(3), big.NewFloat(-4)), `{"num":"3J-4"}`},
+		{"infinity", NewNum(new(big.Float).SetInf(true)), `{"num":"-Inf"}`},
+		{"vector", &Arr{Values: []Value{NewInt(1), newFloat64(2.5)}}, `[1,2.5]`},
+		{"empty vector", &Arr{Values: []Value{}}, `[]`},
+		{"string", NewStr("héllo"), `"héllo"`},
+		{"string of a number", NewStr("12"), `"12"`},
+		{"string of a fraction", NewStr("1/3"), `"1/3"`},
+		{"string of an infinity", NewStr("+Inf"), `"+Inf"`},
+		{"nested", &Arr{Values: []Value{NewStr("ab"), &Arr{Values: []Value{NewInt(1)}}}}, `["ab",[1]]`},
+		{"matrix", &Arr{Shape: []int{2, 2}, Values: []Value{NewInt(1), NewInt(2), NewInt(3), NewInt(4)}}, `{"shape":[2,2],"values":[1,2,3,4]}`},
+		{"character matrix", &Arr{Shape: []int{2, 1}, Values: NewStr("ab").Values}, `{"shape":[2,1],"values":"ab"}`},
+		{"scalar", &Arr{Shape: []int{}, Values: []Value{NewStr("ab")}}, `{"shape":[],"values":["ab"]}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			data, err := json.Marshal(test.val)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if string(data) != test.json {
+				t.Errorf("invalid encoding:\nexpected: %s\nreturned: %s", test.json, data)
+			}
+
+			val, err := DecodeJSON(data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if val.Stringify() != test.val.Stringify() {
+				t.Errorf("invalid decoding:\nexpected: %s\nreturned: %s", test.val.Stringify(), val.Stringify())
+			} else if fmt.Sprintf("%T", val) != fmt.Sprintf("%T", test.val) {
+				t.Errorf("invalid decoding: expected a %T but got a %T", test.val, val)
+			}
+		})
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	tests := []struct {
+		label string
+		json  string
+		err   string
+	}{
+		{"null", `null`, "cannot decode <nil> from JSON"},
+		{"trailing data", `1 2`, "unexpected data after the JSON value"},
+		{"object without a shape", `{"a":1}`, "expecting a number or an object with a shape and values"},
+		{"number that is not one", `{"num":"x"}`, `invalid number "x"`},
+		{"shape that does not fit", `{"shape":[2,2],"values":[1,2,3]}`, "expecting 4 values for a shape of [2 2] but got 3"},
+		{"negative shape", `{"shape":[-1],"values":[]}`, "invalid shape -1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			_, err := DecodeJSON([]byte(test.json))
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expected error %q but got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestJSONTypes(t *testing.T) {
+	var n Num
+	if err := json.Unmarshal([]byte(`{"num":"2/4"}`), &n); err != nil || n.Stringify() != "1/2" {
+		t.Errorf("expected 1/2 but got %s (%v)", n.Stringify(), err)
+	}
+	if err := json.Unmarshal([]byte(`"12"`), &n); err == nil {
+		t.Errorf("expected an error decoding a string into a number")
+	}
+
+	var c Char
+	if err := json.Unmarshal([]byte(`"λ"`), &c); err != nil || c.Value != 'λ' {
+		t.Errorf("expected λ but got %c (%v)", c.Value, err)
+	}
+
+	var a Arr
+	if err := json.Unmarshal([]byte(`1`), &a); err == nil {
+		t.Errorf("expected an error decoding a number into an array")
+	}
+}
@@ -0,0 +1,739 @@
+package value
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+)
+
+// guard is the number of bits more than the precision of the environment
+// that the functions in this file are computed with, so that rounding their
+// results to the precision leaves them correct in all but the rarest cases.
+const guard = 64
+
+// maxFactorial is the largest factorial that is computed, or the largest
+// number of terms a binomial coefficient is computed with.
+const maxFactorial = 1 << 16
+
+func newf(prec uint) *big.Float {
+	return new(big.Float).SetPrec(prec)
+}
+
+// exponent is the binary exponent of x, so that x is in [2^(e-1), 2^e).
+func exponent(x *big.Float) int {
+	return x.MantExp(nil)
+}
+
+// converged reports whether adding term to sum no longer changes its first
+// prec bits.
+func converged(term, sum *big.Float, prec uint) bool {
+	return term.Sign() == 0 || sum.Sign() != 0 && exponent(term) < exponent(sum)-int(prec)
+}
+
+// approx computes fn at guard bits more than the precision of env, and rounds
+// the result to the precision of env.
+func approx(env *Environment, fn func(prec uint) (*big.Float, error)) (*Num, error) {
+	res, err := fn(env.Precision() + guard)
+	if err != nil {
+		return nil, err
+	} else if res.IsInf() {
+		return nil, errors.New("result is out of range")
+	}
+	return NewNum(env.float().Set(res)), nil
+}
+
+// bigPi computes π with the Gauss-Legendre algorithm, which doubles the
+// number of correct digits with every iteration.
+func bigPi(prec uint) *big.Float {
+	p := prec + guard
+	a := newf(p).SetInt64(1)
+	b := newf(p).Sqrt(newf(p).SetFloat64(0.5))
+	t := newf(p).SetFloat64(0.25)
+	scale := newf(p).SetInt64(1)
+	for i := 0; i < bits.Len(p)+2; i++ {
+		next := newf(p).Add(a, b)
+		next.Quo(next, big.NewFloat(2))
+		b.Sqrt(newf(p).Mul(a, b))
+		d := newf(p).Sub(a, next)
+		t.Sub(t, d.Mul(d, d).Mul(d, scale))
+		scale.Mul(scale, big.NewFloat(2))
+		a = next
+	}
+	res := newf(p).Add(a, b)
+	res.Mul(res, res)
+	return res.Quo(res, t.Mul(t, big.NewFloat(4)))
+}
+
+// bigExp computes e^x by halving x until the Taylor series converges
+// quickly, then squaring the result back.
+func bigExp(x *big.Float, prec uint) (*big.Float, error) {
+	if x.Sign() == 0 {
+		return newf(prec).SetInt64(1), nil
+	} else if x.IsInf() || exponent(x) > 32 {
+		if x.Sign() < 0 {
+			return newf(prec), nil
+		}
+		return nil, errors.New("result is out of range")
+	}
+
+	k := exponent(x) + 8
+	if k < 0 {
+		k = 0
+	}
+	p := prec + uint(k)
+	r := newf(p).SetMantExp(x, -k)
+
+	sum := newf(p).SetInt64(1)
+	term := newf(p).SetInt64(1)
+	for n := int64(1); ; n++ {
+		term.Mul(term, r)
+		term.Quo(term, newf(p).SetInt64(n))
+		sum.Add(sum, term)
+		if converged(term, sum, p) {
+			break
+		}
+	}
+
+	for i := 0; i < k; i++ {
+		sum.Mul(sum, sum)
+	}
+	if sum.IsInf() {
+		return nil, errors.New("result is out of range")
+	}
+	return sum, nil
+}
+
+// atanhSeries computes atanh(z) = z + z³/3 + z⁵/5 + ..., which converges
+// quickly for small z.
+func atanhSeries(z *big.Float, prec uint) *big.Float {
+	zz := newf(prec).Mul(z, z)
+	sum := newf(prec).Set(z)
+	term := newf(prec).Set(z)
+	for n := int64(3); ; n += 2 {
+		term.Mul(term, zz)
+		t := newf(prec).Quo(term, newf(prec).SetInt64(n))
+		sum.Add(sum, t)
+		if converged(t, sum, prec) {
+			break
+		}
+	}
+	return sum
+}
+
+// bigLog computes the natural logarithm of a positive x. Writing x as m·2^e
+// with m in [0.5, 1), log x = log m + e·log 2, and log m = 2·atanh((m-1)/(m+1))
+// with (m-1)/(m+1) no bigger than 1/3.
+func bigLog(x *big.Float, prec uint) *big.Float {
+	p := prec + guard
+	m := newf(p)
+	e := x.MantExp(m)
+	if e == 0 || e == 1 {
+		// Numbers close to 1 are not reduced, as the logarithm of 2 would
+		// cancel out most of the logarithm of m.
+		m.Set(x)
+		e = 0
+	}
+
+	num := newf(p).Sub(m, newf(p).SetInt64(1))
+	den := newf(p).Add(m, newf(p).SetInt64(1))
+	res := atanhSeries(num.Quo(num, den), p)
+	res.Mul(res, big.NewFloat(2))
+	if e != 0 {
+		third := newf(p).Quo(newf(p).SetInt64(1), newf(p).SetInt64(3))
+		ln2 := atanhSeries(third, p)
+		ln2.Mul(ln2, big.NewFloat(2))
+		res.Add(res, ln2.Mul(ln2, newf(p).SetInt64(int64(e))))
+	}
+	return res
+}
+
+// reduce returns x minus the multiple of 2π that is nearest to it.
+func reduce(x *big.Float, prec uint) *big.Float {
+	p := prec
+	if e := exponent(x); e > 0 {
+		p += uint(e)
+	}
+	twoPi := bigPi(p)
+	twoPi.Mul(twoPi, big.NewFloat(2))
+
+	q := newf(p).Quo(x, twoPi)
+	q.Add(q, big.NewFloat(0.5))
+	n := floor(q)
+	return newf(prec).Sub(x, n.Mul(n, twoPi))
+}
+
+// bigSin computes sin x with its Taylor series, once x is between -π and π.
+func bigSin(x *big.Float, prec uint) *big.Float {
+	r := reduce(x, prec)
+	rr := newf(prec).Mul(r, r)
+	sum := newf(prec).Set(r)
+	term := newf(prec).Set(r)
+	for n := int64(1); ; n++ {
+		term.Mul(term, rr)
+		term.Quo(term, newf(prec).SetInt64(-2*n*(2*n+1)))
+		sum.Add(sum, term)
+		if converged(term, sum, prec) {
+			break
+		}
+	}
+	return sum
+}
+
+// bigCos computes cos x with its Taylor series, once x is between -π and π.
+func bigCos(x *big.Float, prec uint) *big.Float {
+	r := reduce(x, prec)
+	rr := newf(prec).Mul(r, r)
+	sum := newf(prec).SetInt64(1)
+	term := newf(prec).SetInt64(1)
+	for n := int64(1); ; n++ {
+		term.Mul(term, rr)
+		term.Quo(term, newf(prec).SetInt64(-(2*n-1)*(2*n)))
+		sum.Add(sum, term)
+		if converged(term, sum, prec) {
+			break
+		}
+	}
+	return sum
+}
+
+// bigAtan computes atan x. Numbers larger than 1 use atan x = π/2 - atan 1/x,
+// and the rest are halved with atan x = 2·atan(x / (1 + √(1 + x²))) until the
+// Taylor series converges quickly.
+func bigAtan(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		return newf(prec)
+	}
+
+	y := newf(prec).Abs(x)
+	inverted := y.Cmp(big.NewFloat(1)) > 0
+	if inverted {
+		y.Quo(newf(prec).SetInt64(1), y)
+	}
+
+	k := 0
+	for ; exponent(y) > -8; k++ {
+		d := newf(prec).Mul(y, y)
+		d.Add(d, big.NewFloat(1))
+		d.Sqrt(d)
+		d.Add(d, big.NewFloat(1))
+		y.Quo(y, d)
+	}
+
+	yy := newf(prec).Mul(y, y)
+	sum := newf(prec).Set(y)
+	term := newf(prec).Set(y)
+	for n := int64(3); ; n += 2 {
+		term.Mul(term, yy)
+		term.Neg(term)
+		t := newf(prec).Quo(term, newf(prec).SetInt64(n))
+		sum.Add(sum, t)
+		if converged(t, sum, prec) {
+			break
+		}
+	}
+	res := newf(prec).SetMantExp(sum, k)
+
+	if inverted {
+		half := bigPi(prec)
+		half.Quo(half, big.NewFloat(2))
+		res.Sub(half, res)
+	}
+	if x.Sign() < 0 {
+		res.Neg(res)
+	}
+	return res
+}
+
+// bigAtan2 is the angle of the point (x, y) from the positive x axis, from -π
+// to π.
+func bigAtan2(y, x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		if y.Sign() == 0 {
+			return newf(prec)
+		}
+		res := bigPi(prec)
+		res.Quo(res, big.NewFloat(2))
+		if y.Sign() < 0 {
+			res.Neg(res)
+		}
+		return res
+	}
+
+	res := bigAtan(newf(prec).Quo(y, x), prec)
+	if x.Sign() < 0 {
+		if y.Sign() < 0 {
+			res.Sub(res, bigPi(prec))
+		} else {
+			res.Add(res, bigPi(prec))
+		}
+	}
+	return res
+}
+
+// within fails unless x is from min to max.
+func within(x *big.Float, min, max float64) error {
+	if x.Cmp(big.NewFloat(min)) < 0 || x.Cmp(big.NewFloat(max)) > 0 {
+		return fmt.Errorf("expecting a number from %g to %g but got %s", min, max, x.Text('g', -1))
+	}
+	return nil
+}
+
+// bigAsin computes asin x as atan(x / √(1 - x²)).
+func bigAsin(x *big.Float, prec uint) (*big.Float, error) {
+	if err := within(x, -1, 1); err != nil {
+		return nil, err
+	}
+	if x.Cmp(big.NewFloat(1)) == 0 || x.Cmp(big.NewFloat(-1)) == 0 {
+		res := bigPi(prec)
+		res.Quo(res, big.NewFloat(2))
+		if x.Sign() < 0 {
+			res.Neg(res)
+		}
+		return res, nil
+	}
+
+	// (1 - x)(1 + x) keeps more of the precision of 1 - x² for x close to 1.
+	d := newf(prec).Sub(big.NewFloat(1), x)
+	d.Mul(d, newf(prec).Add(big.NewFloat(1), x))
+	d.Sqrt(d)
+	return bigAtan(d.Quo(x, d), prec), nil
+}
+
+// bigAcos computes acos x as 2·atan(√((1 - x) / (1 + x))), which keeps its
+// precision for x close to 1.
+func bigAcos(x *big.Float, prec uint) (*big.Float, error) {
+	if err := within(x, -1, 1); err != nil {
+		return nil, err
+	} else if x.Cmp(big.NewFloat(-1)) == 0 {
+		return bigPi(prec), nil
+	}
+
+	d := newf(prec).Sub(big.NewFloat(1), x)
+	d.Quo(d, newf(prec).Add(big.NewFloat(1), x))
+	res := bigAtan(d.Sqrt(d), prec)
+	return res.Mul(res, big.NewFloat(2)), nil
+}
+
+// hyperbolic computes (e^x + sign·e^-x) / 2, which is sinh x when sign is -1
+// and cosh x when it is 1. Small numbers get more precision, as the two
+// exponentials cancel out.
+func hyperbolic(x *big.Float, sign int64, prec uint) (*big.Float, error) {
+	p := prec
+	if e := exponent(x); e < 0 {
+		p += uint(-e)
+	}
+	pos, err := bigExp(x, p)
+	if err != nil {
+		return nil, err
+	}
+	neg := newf(p).Quo(newf(p).SetInt64(sign), pos)
+	res := newf(p).Add(pos, neg)
+	return res.Quo(res, big.NewFloat(2)), nil
+}
+
+// bigTanh computes tanh x as (e^2x - 1) / (e^2x + 1). Numbers that are large
+// enough for that to overflow are ±1 at any precision.
+func bigTanh(x *big.Float, prec uint) (*big.Float, error) {
+	if exponent(x) > 30 {
+		return newf(prec).SetInt64(int64(x.Sign())), nil
+	}
+
+	p := prec
+	if e := exponent(x); e < 0 {
+		p += uint(-e)
+	}
+	x2 := newf(p).Mul(x, big.NewFloat(2))
+	e, err := bigExp(x2, p)
+	if err != nil {
+		return nil, err
+	}
+	num := newf(p).Sub(e, big.NewFloat(1))
+	return num.Quo(num, newf(p).Add(e, big.NewFloat(1))), nil
+}
+
+// bigAsinh computes asinh x as log(|x| + √(x² + 1)), with the sign of x.
+func bigAsinh(x *big.Float, prec uint) *big.Float {
+	p := prec
+	if e := exponent(x); e < 0 {
+		p += uint(-e)
+	}
+	ax := newf(p).Abs(x)
+	d := newf(p).Mul(ax, ax)
+	d.Add(d, big.NewFloat(1))
+	d.Sqrt(d)
+	res := bigLog(d.Add(d, ax), p)
+	if x.Sign() < 0 {
+		res.Neg(res)
+	}
+	return res
+}
+
+// bigAcosh computes acosh x as log(x + √(x² - 1)).
+func bigAcosh(x *big.Float, prec uint) (*big.Float, error) {
+	if x.Cmp(big.NewFloat(1)) < 0 {
+		return nil, fmt.Errorf("expecting a number no less than 1 but got %s", x.Text('g', -1))
+	}
+	d := newf(prec).Mul(x, x)
+	d.Sub(d, big.NewFloat(1))
+	d.Sqrt(d)
+	return bigLog(d.Add(d, x), prec), nil
+}
+
+// bigAtanh computes atanh x as log((1 + x) / (1 - x)) / 2.
+func bigAtanh(x *big.Float, prec uint) (*big.Float, error) {
+	if x.Cmp(big.NewFloat(-1)) <= 0 || x.Cmp(big.NewFloat(1)) >= 0 {
+		return nil, fmt.Errorf("expecting a number between -1 and 1 but got %s", x.Text('g', -1))
+	}
+	p := prec
+	if e := exponent(x); e < 0 {
+		p += uint(-e)
+	}
+	d := newf(p).Add(big.NewFloat(1), x)
+	d.Quo(d, newf(p).Sub(big.NewFloat(1), x))
+	res := bigLog(d, p)
+	return res.Quo(res, big.NewFloat(2)), nil
+}
+
+// bigSqrt computes √x for a non-negative x. big.Float panics when x is
+// negative.
+func bigSqrt(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		return newf(prec)
+	}
+	return newf(prec).Sqrt(x)
+}
+
+// squareRoot is √n. Negative and complex numbers have complex roots, with
+// √(a + bi) = √((|z| + a) / 2) + √((|z| - a) / 2)i, the imaginary part
+// taking the sign of b.
+func squareRoot(env *Environment, n *Num) (*Num, error) {
+	if !n.complex() && n.sign() >= 0 {
+		return approx(env, func(prec uint) (*big.Float, error) {
+			return bigSqrt(n.Float(), prec), nil
+		})
+	}
+
+	prec := env.Precision() + guard
+	a, b := n.parts()
+	abs := newf(prec).Mul(a, a)
+	abs.Add(abs, newf(prec).Mul(b, b))
+	abs.Sqrt(abs)
+	re := newf(prec).Add(abs, a)
+	re = bigSqrt(re.Quo(re, big.NewFloat(2)), prec)
+	im := newf(prec).Sub(abs, a)
+	im = bigSqrt(im.Quo(im, big.NewFloat(2)), prec)
+	if b.Sign() < 0 {
+		im.Neg(im)
+	}
+	return NewComplex(env.float().Set(re), env.float().Set(im)), nil
+}
+
+// exponential is e^n. For complex numbers, e^(a + bi) = e^a·(cos b + i sin b).
+func exponential(env *Environment, n *Num) (*Num, error) {
+	if !n.complex() {
+		return approx(env, func(prec uint) (*big.Float, error) {
+			return bigExp(n.Float(), prec)
+		})
+	}
+
+	prec := env.Precision() + guard
+	scale, err := bigExp(n.Value, prec)
+	if err != nil {
+		return nil, err
+	}
+	re := bigCos(n.Imag, prec)
+	im := bigSin(n.Imag, prec)
+	return NewComplex(env.float().Mul(scale, re), env.float().Mul(scale, im)), nil
+}
+
+// logarithm is the natural logarithm of n. Negative and complex numbers have
+// complex logarithms, log z = log |z| + i·phase z.
+func logarithm(env *Environment, n *Num) (*Num, error) {
+	if !n.complex() && n.sign() > 0 {
+		return approx(env, func(prec uint) (*big.Float, error) {
+			return bigLog(n.Float(), prec), nil
+		})
+	} else if n.sign() == 0 && !n.complex() {
+		return nil, errors.New("logarithm of zero")
+	}
+
+	prec := env.Precision() + guard
+	a, b := n.parts()
+	abs := newf(prec).Mul(a, a)
+	abs.Add(abs, newf(prec).Mul(b, b))
+	abs.Sqrt(abs)
+	re := bigLog(abs, prec)
+	im := bigAtan2(b, a, prec)
+	return NewComplex(env.float().Set(re), env.float().Set(im)), nil
+}
+
+// bigInt returns the value of n as a big.Int, if it is an integer.
+func (n *Num) bigInt() (*big.Int, bool) {
+	if i, ok := n.int64(); ok {
+		return big.NewInt(i), true
+	} else if n.kind == kindBigInt {
+		return new(big.Int).Set(n.b), true
+	} else if n.complex() {
+		return nil, false
+	} else if n.Rat != nil {
+		if !n.Rat.IsInt() {
+			return nil, false
+		}
+		return new(big.Int).Set(n.Rat.Num()), true
+	} else if n.kind != kindBig || n.Value.IsInf() || !n.Value.IsInt() {
+		return nil, false
+	}
+	i, _ := n.Value.Int(nil)
+	return i, true
+}
+
+// newBigInt creates a number out of an integer, which is only kept exact in
+// exact mode or when it fits in a machine integer.
+func newBigInt(env *Environment, i *big.Int) *Num {
+	if i.IsInt64() && fits(env, i.Int64()) {
+		return NewInt(i.Int64())
+	} else if env.Exact() {
+		return newRat(env, new(big.Rat).SetInt(i))
+	}
+	return NewNum(env.float().SetInt(i))
+}
+
+// natural returns the value of n when it is a non-negative integer.
+func natural(n *Num) (int64, error) {
+	i, ok := n.int64()
+	if !ok || i < 0 {
+		return 0, fmt.Errorf("expecting a non-negative integer but got %s", n.Stringify())
+	}
+	return i, nil
+}
+
+var pi = &Fn{
+	Argc: 1,
+	Impl: numunop(func(env *Environment, arg *Num) (*Num, error) {
+		if arg.complex() {
+			res := NewNum(env.float().Set(bigPi(env.Precision())))
+			return complexMul(env, res, arg), nil
+		}
+		return approx(env, func(prec uint) (*big.Float, error) {
+			res := bigPi(prec)
+			return res.Mul(res, arg.Float()), nil
+		})
+	}),
+}
+
+// circle is APL's dyadic `○`, in which the left argument selects one of the
+// following functions of the right argument:
+//
+//	0  √(1 - x²)     ¯1  asin x
+//	1  sin x         ¯2  acos x
+//	2  cos x         ¯3  atan x
+//	3  tan x         ¯4  √(x² - 1)
+//	4  √(1 + x²)     ¯5  asinh x
+//	5  sinh x        ¯6  acosh x
+//	6  cosh x        ¯7  atanh x
+//	7  tanh x        ¯9  x
+//	9  real part     ¯10 conjugate
+//	10 magnitude     ¯11 x × 0J1
+//	11 imaginary     ¯12 e ^ (x × 0J1)
+//	12 phase
+var circle = &Op{
+	Prec: PrecMultiplicative,
+	Impl: numbinop(func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+		k, ok := lhs.int64()
+		if !ok || k < -12 || k > 12 || k == 8 || k == -8 {
+			return nil, fmt.Errorf("expecting a circle function from -12 to 12 but got %s", lhs.Stringify())
+		}
+
+		i := NewComplex(big.NewFloat(0), big.NewFloat(1))
+		switch k {
+		case 9:
+			return realPart(env, rhs)
+		case 10:
+			return absolute(env, rhs)
+		case 11:
+			return imagPart(env, rhs)
+		case 12:
+			return angle(env, rhs)
+		case -9:
+			return rhs, nil
+		case -10:
+			return conj(env, rhs)
+		case -11:
+			return complexMul(env, rhs, i), nil
+		case -12:
+			return exponential(env, complexMul(env, rhs, i))
+		}
+
+		if err := realOnly(rhs); err != nil {
+			return nil, err
+		}
+		x := rhs.Float()
+		switch k {
+		case 0:
+			sq := complexMul(env, rhs, rhs)
+			return squareRoot(env, complexSub(env, NewInt(1), sq))
+		case 4:
+			sq := complexMul(env, rhs, rhs)
+			return squareRoot(env, complexAdd(env, NewInt(1), sq))
+		case -4:
+			sq := complexMul(env, rhs, rhs)
+			return squareRoot(env, complexSub(env, sq, NewInt(1)))
+		}
+		return approx(env, func(prec uint) (*big.Float, error) {
+			switch k {
+			case 1:
+				return bigSin(x, prec), nil
+			case 2:
+				return bigCos(x, prec), nil
+			case 3:
+				res := bigSin(x, prec)
+				return res.Quo(res, bigCos(x, prec)), nil
+			case 5:
+				return hyperbolic(x, -1, prec)
+			case 6:
+				return hyperbolic(x, 1, prec)
+			case 7:
+				return bigTanh(x, prec)
+			case -1:
+				return bigAsin(x, prec)
+			case -2:
+				return bigAcos(x, prec)
+			case -3:
+				return bigAtan(x, prec), nil
+			case -5:
+				return bigAsinh(x, prec), nil
+			case -6:
+				return bigAcosh(x, prec)
+			}
+			return bigAtanh(x, prec)
+		})
+	}),
+}
+
+var exp_ = &Fn{
+	Argc: 1,
+	Impl: numunop(exponential),
+}
+
+var log_ = &Fn{
+	Argc: 1,
+	Impl: numunop(logarithm),
+}
+
+// logBase is APL's dyadic `⍟`, the logarithm of the right argument in the
+// base of the left one.
+var logBase = &Op{
+	Prec: PrecPower,
+	Impl: numbinop(func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+		if !lhs.complex() && !rhs.complex() && lhs.sign() > 0 && rhs.sign() > 0 {
+			if cmp(env, lhs, NewInt(1)) == 0 {
+				return nil, errors.New("division by zero")
+			}
+			return approx(env, func(prec uint) (*big.Float, error) {
+				res := bigLog(rhs.Float(), prec)
+				return res.Quo(res, bigLog(lhs.Float(), prec)), nil
+			})
+		}
+
+		base, err := logarithm(env, lhs)
+		if err != nil {
+			return nil, err
+		} else if base.sign() == 0 && !base.complex() {
+			return nil, errors.New("division by zero")
+		}
+		res, err := logarithm(env, rhs)
+		if err != nil {
+			return nil, err
+		}
+		if base.complex() || res.complex() {
+			return complexQuo(env, res, base), nil
+		}
+		return NewNum(env.float().Quo(res.Float(), base.Float())), nil
+	}),
+}
+
+var sqrt = &Fn{
+	Argc: 1,
+	Impl: numunop(squareRoot),
+}
+
+// factorial is APL's monadic `!`, which is only defined on non-negative
+// integers.
+var factorial = &Fn{
+	Argc: 1,
+	Impl: numunop(func(env *Environment, arg *Num) (*Num, error) {
+		n, err := natural(arg)
+		if err != nil {
+			return nil, err
+		} else if n > maxFactorial {
+			return nil, fmt.Errorf("factorial of %d is too large", n)
+		}
+		return newBigInt(env, new(big.Int).MulRange(1, n)), nil
+	}),
+}
+
+// binomial is APL's dyadic `k ! n`, the number of ways of picking k items out
+// of n, which is 0 when k is larger than n.
+var binomial = &Op{
+	Prec: PrecMultiplicative,
+	Impl: numbinop(func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+		k, err := natural(lhs)
+		if err != nil {
+			return nil, err
+		}
+		n, err := natural(rhs)
+		if err != nil {
+			return nil, err
+		}
+		if k > n {
+			return NewInt(0), nil
+		} else if k > maxFactorial && n-k > maxFactorial {
+			return nil, fmt.Errorf("binomial of %d and %d is too large", k, n)
+		}
+		return newBigInt(env, new(big.Int).Binomial(n, k)), nil
+	}),
+}
+
+// integers returns the values of lhs and rhs, which have to be integers.
+func integers(lhs, rhs *Num) (*big.Int, *big.Int, error) {
+	a, ok := lhs.bigInt()
+	if !ok {
+		return nil, nil, fmt.Errorf("expecting an integer but got %s", lhs.Stringify())
+	}
+	b, ok := rhs.bigInt()
+	if !ok {
+		return nil, nil, fmt.Errorf("expecting an integer but got %s", rhs.Stringify())
+	}
+	return a.Abs(a), b.Abs(b), nil
+}
+
+// gcd is APL's `∨`, the greatest common divisor, which is the same as or on
+// booleans.
+var gcd = &Op{
+	Prec: PrecLogical,
+	Impl: numbinop(func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+		a, b, err := integers(lhs, rhs)
+		if err != nil {
+			return nil, err
+		}
+		return newBigInt(env, new(big.Int).GCD(nil, nil, a, b)), nil
+	}),
+}
+
+// lcm is APL's `∧`, the least common multiple, which is the same as and on
+// booleans.
+var lcm = &Op{
+	Prec: PrecLogical,
+	Impl: numbinop(func(env *Environment, lhs *Num, rhs *Num) (*Num, error) {
+		a, b, err := integers(lhs, rhs)
+		if err != nil {
+			return nil, err
+		}
+		if a.Sign() == 0 || b.Sign() == 0 {
+			return NewInt(0), nil
+		}
+		res := new(big.Int).Mul(a, b)
+		return newBigInt(env, res.Quo(res, new(big.Int).GCD(nil, nil, a, b))), nil
+	}),
+}
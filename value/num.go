@@ -0,0 +1,437 @@
+package value
+
+import (
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// kind is how a number is stored. Numbers that fit in an int64 or, at the
+// precision of a float64, a float64 are kept as machine values, which
+// arithmetic on them uses directly. They are only turned into a big.Float
+// when an operation has no fast path. Integer results that overflow an int64
+// are promoted to a big.Int, which keeps them exact, and other results to a
+// big.Float when they need more precision than a machine value has.
+type kind uint8
+
+const (
+	kindBig kind = iota
+	kindInt
+	kindFloat
+	kindBigInt
+)
+
+// float64Prec is the number of mantissa bits in a float64. Arithmetic on
+// float64 values gives the same results as on big.Float values of this
+// precision, rounded to the nearest even number.
+const float64Prec = 53
+
+// NewInt creates a number out of an integer.
+func NewInt(i int64) *Num {
+	return &Num{kind: kindInt, i: i}
+}
+
+// newFloat64 creates a number out of a float64, storing it as an integer
+// when it is one.
+func newFloat64(f float64) *Num {
+	n := float64Num(f)
+	return &n
+}
+
+// float64Num is newFloat64 for numbers that are not kept on the heap.
+func float64Num(f float64) Num {
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 && !(f == 0 && math.Signbit(f)) {
+		return Num{kind: kindInt, i: int64(f)}
+	}
+	return Num{kind: kindFloat, f: f}
+}
+
+// newInteger creates a number out of a big.Int, storing it as an int64 when
+// it fits one.
+func newInteger(i *big.Int) *Num {
+	if i.IsInt64() {
+		return NewInt(i.Int64())
+	}
+	return &Num{kind: kindBigInt, b: i}
+}
+
+// NewNum creates a number out of a big.Float, storing it as a machine value
+// when it can be one without losing anything. Numbers are printed with as
+// many digits as their precision calls for, so only integers of at least a
+// float64's precision and fractions of exactly that precision are converted.
+func NewNum(f *big.Float) *Num {
+	n := &Num{}
+	n.setFloat(f)
+	return n
+}
+
+// setFloat sets n, which must be zero, to f the way NewNum does.
+func (n *Num) setFloat(f *big.Float) {
+	if f.IsInt() && f.Prec() >= float64Prec {
+		if i, acc := f.Int64(); acc == big.Exact {
+			n.kind, n.i = kindInt, i
+			return
+		}
+	} else if !f.IsInt() && f.Prec() == float64Prec {
+		if x, acc := f.Float64(); acc == big.Exact && normal(x) {
+			n.kind, n.f = kindFloat, x
+			return
+		}
+	}
+	n.Value = f
+}
+
+// Float returns the value of the number, or its real part when it is
+// complex, as a big.Float, which must not be modified.
+func (n *Num) Float() *big.Float {
+	switch n.kind {
+	case kindInt:
+		return new(big.Float).SetInt64(n.i)
+	case kindFloat:
+		return new(big.Float).SetPrec(float64Prec).SetFloat64(n.f)
+	case kindBigInt:
+		return new(big.Float).SetInt(n.b)
+	}
+	return n.Value
+}
+
+func (n *Num) sign() int {
+	switch n.kind {
+	case kindInt:
+		switch {
+		case n.i < 0:
+			return -1
+		case n.i > 0:
+			return 1
+		}
+		return 0
+	case kindFloat:
+		switch {
+		case n.f < 0:
+			return -1
+		case n.f > 0:
+			return 1
+		}
+		return 0
+	case kindBigInt:
+		return n.b.Sign()
+	}
+	return n.Value.Sign()
+}
+
+// int64 returns the number as an int64, if it is one.
+func (n *Num) int64() (int64, bool) {
+	if n.kind == kindInt {
+		return n.i, true
+	} else if n.kind != kindBig || n.complex() || !n.Value.IsInt() {
+		return 0, false
+	}
+	i, acc := n.Value.Int64()
+	return i, acc == big.Exact
+}
+
+// float64 returns the number as a float64, if it is one or is an integer
+// that a float64 holds exactly.
+func (n *Num) float64() (float64, bool) {
+	switch n.kind {
+	case kindInt:
+		return intFloat64(n.i)
+	case kindFloat:
+		return n.f, true
+	}
+	return 0, false
+}
+
+// realIn returns the value of a real number as a big.Float, which is z for
+// machine numbers. z must hold at least int64Prec bits.
+func (n *Num) realIn(z *big.Float) *big.Float {
+	switch n.kind {
+	case kindInt:
+		return z.SetInt64(n.i)
+	case kindFloat:
+		return z.SetFloat64(n.f)
+	}
+	return n.Float()
+}
+
+// intFloat64 returns i as a float64, if a float64 holds it exactly.
+func intFloat64(i int64) (float64, bool) {
+	if i >= -1<<float64Prec && i <= 1<<float64Prec {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+// minNormal is the smallest float64 with a full mantissa.
+const minNormal = 2.2250738585072014e-308
+
+// normal reports whether x is a finite float64 with a full mantissa, which
+// rules out results that a big.Float would have kept more precisely.
+func normal(x float64) bool {
+	return x == 0 || !math.IsInf(x, 0) && !math.IsNaN(x) && math.Abs(x) >= minNormal
+}
+
+// fits reports whether env keeps every bit of the integer i, and prints it
+// the same way as an int64.
+func fits(env *Environment, i int64) bool {
+	return fitsPrec(env.Precision(), i)
+}
+
+func fitsPrec(prec uint, i int64) bool {
+	u := uint64(i)
+	if i < 0 {
+		u = -u
+	}
+	return prec >= float64Prec && uint(bits.Len64(u)) <= prec
+}
+
+// machine reports whether float64 arithmetic gives the same results as
+// big.Float arithmetic in env, which is only the case when ⎕FPREC is 53,
+// ⎕RM rounds to the nearest even number and numbers are not kept exact. At
+// the default ⎕FPREC of 64, floats that are not integers are computed as
+// big.Float values, and only integers take a fast path.
+func machine(env *Environment) bool {
+	return env.Precision() == float64Prec && env.RoundingMode() == big.ToNearestEven && !env.Exact()
+}
+
+// int64Prec is the precision it takes to keep every bit of an int64.
+const int64Prec = 64
+
+// exactInts reports whether env keeps integers exact however large they
+// grow, which it does at any precision that keeps every int64 exact. At
+// lower precisions, integers that do not fit are rounded like any number.
+func exactInts(env *Environment) bool {
+	return env.Precision() >= int64Prec
+}
+
+// machineMode is what arithmetic on machine numbers needs to know of an
+// environment, which is read once for a whole array rather than once for
+// every item.
+type machineMode struct {
+	prec   uint
+	floats bool
+}
+
+func modeOf(env *Environment) machineMode {
+	return machineMode{prec: env.Precision(), floats: machine(env)}
+}
+
+// arith is an operation on machine numbers and big integers. ints reports
+// false when its result overflows or is not an integer, and bigs when its
+// result is not an integer or is too large to compute. reals sets z to the
+// result of the operation on big.Float values, which is what it gives for
+// real numbers that are not kept exact, and returns nil when the operation
+// has to deal with them itself. Any of them may be nil when the operation
+// has no such version.
+type arith struct {
+	ints   func(a, b int64) (int64, bool)
+	bigs   func(a, b *big.Int) (*big.Int, bool)
+	floats func(a, b float64) float64
+	reals  func(z, a, b *big.Float) *big.Float
+}
+
+// apply computes the operation on two machine numbers, using ints when both
+// of them are integers and floats otherwise. It reports false when the
+// result is not a machine number that an environment in mode would give.
+func (op *arith) apply(mode machineMode, lhs, rhs *Num) (Num, bool) {
+	if lhs.kind == kindInt && rhs.kind == kindInt && op.ints != nil {
+		if res, ok := op.ints(lhs.i, rhs.i); ok && fitsPrec(mode.prec, res) {
+			return Num{kind: kindInt, i: res}, true
+		}
+	}
+
+	if op.floats == nil || !mode.floats {
+		return Num{}, false
+	}
+	a, aok := lhs.float64()
+	b, bok := rhs.float64()
+	if !aok || !bok {
+		return Num{}, false
+	}
+	if res := op.floats(a, b); normal(res) {
+		return float64Num(res), true
+	}
+	return Num{}, false
+}
+
+// fast computes an operation on machine numbers, or on integers that
+// overflow them, which env keeps exact. fast reports false when the
+// operation has to be done on big numbers instead.
+func fast(env *Environment, lhs, rhs *Num, op *arith) (*Num, bool) {
+	if lhs.kind == kindBig || rhs.kind == kindBig {
+		return nil, false
+	}
+	if res, ok := op.apply(modeOf(env), lhs, rhs); ok {
+		return &res, true
+	}
+
+	// Floats are never integers, which float64Num stores as kindInt.
+	if op.bigs == nil || lhs.kind == kindFloat || rhs.kind == kindFloat || !exactInts(env) {
+		return nil, false
+	}
+	a, aok := lhs.bigInt()
+	b, bok := rhs.bigInt()
+	if !aok || !bok {
+		return nil, false
+	}
+	if res, ok := op.bigs(a, b); ok {
+		return newInteger(res), true
+	}
+	return nil, false
+}
+
+func addInt(a, b int64) (int64, bool) {
+	res := a + b
+	return res, (res > a) == (b > 0)
+}
+
+func subInt(a, b int64) (int64, bool) {
+	res := a - b
+	return res, (res < a) == (b > 0)
+}
+
+func mulInt(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	res := a * b
+	return res, res/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+}
+
+func quoInt(a, b int64) (int64, bool) {
+	if b == 0 || a%b != 0 || a == math.MinInt64 && b == -1 {
+		return 0, false
+	}
+	return a / b, true
+}
+
+// powInt raises a to a non-negative power b by repeated squaring.
+func powInt(a, b int64) (int64, bool) {
+	if b < 0 {
+		return 0, false
+	}
+
+	res, sq := int64(1), a
+	for k := b; k != 0; k /= 2 {
+		var ok bool
+		if k%2 != 0 {
+			if res, ok = mulInt(res, sq); !ok {
+				return 0, false
+			}
+		}
+		if k > 1 {
+			if sq, ok = mulInt(sq, sq); !ok {
+				return 0, false
+			}
+		}
+	}
+	return res, true
+}
+
+// modInt is the remainder of dividing b by a, with the sign of a, or b when
+// a is 0.
+func modInt(a, b int64) (int64, bool) {
+	if a == 0 {
+		return b, true
+	} else if a == -1 {
+		return 0, true
+	}
+	res := b % a
+	if res != 0 && (res < 0) != (a < 0) {
+		res += a
+	}
+	return res, true
+}
+
+func quoBig(a, b *big.Int) (*big.Int, bool) {
+	if b.Sign() == 0 {
+		return nil, false
+	}
+	q, r := new(big.Int).QuoRem(a, b, new(big.Int))
+	return q, r.Sign() == 0
+}
+
+// powBig raises a to a non-negative power b, unless the result would be
+// longer than an exact power is allowed to grow.
+func powBig(a, b *big.Int) (*big.Int, bool) {
+	if b.Sign() < 0 || !b.IsInt64() {
+		return nil, false
+	}
+	if bits := a.BitLen(); bits > 1 && b.Int64() > maxRatBits/int64(bits-1) {
+		return nil, false
+	}
+	return new(big.Int).Exp(a, b, nil), true
+}
+
+func modBig(a, b *big.Int) (*big.Int, bool) {
+	if a.Sign() == 0 {
+		return b, true
+	}
+	res := new(big.Int).Rem(b, a)
+	if res.Sign() != 0 && res.Sign() != a.Sign() {
+		res.Add(res, a)
+	}
+	return res, true
+}
+
+var (
+	addArith = &arith{
+		ints: addInt,
+		bigs: func(a, b *big.Int) (*big.Int, bool) {
+			return new(big.Int).Add(a, b), true
+		},
+		floats: func(a, b float64) float64 { return a + b },
+		reals:  (*big.Float).Add,
+	}
+	subArith = &arith{
+		ints: subInt,
+		bigs: func(a, b *big.Int) (*big.Int, bool) {
+			return new(big.Int).Sub(a, b), true
+		},
+		floats: func(a, b float64) float64 { return a - b },
+		reals:  (*big.Float).Sub,
+	}
+	mulArith = &arith{
+		ints: mulInt,
+		bigs: func(a, b *big.Int) (*big.Int, bool) {
+			return new(big.Int).Mul(a, b), true
+		},
+		floats: func(a, b float64) float64 { return a * b },
+		reals:  (*big.Float).Mul,
+	}
+	quoArith = &arith{
+		ints:   quoInt,
+		bigs:   quoBig,
+		floats: func(a, b float64) float64 { return a / b },
+		reals: func(z, a, b *big.Float) *big.Float {
+			if b.Sign() == 0 {
+				return nil
+			}
+			return z.Quo(a, b)
+		},
+	}
+	powArith = &arith{
+		ints: powInt,
+		bigs: powBig,
+	}
+	modArith = &arith{
+		ints: modInt,
+		bigs: modBig,
+		floats: func(a, b float64) float64 {
+			// The conversion keeps the product from being fused into the
+			// subtraction, which would round differently than big.Float.
+			return b - float64(a*math.Floor(b/a))
+		},
+	}
+)
+
+// ariths holds the built-in operators that have a version on machine
+// numbers, which arrays of them are combined and reduced with directly.
+var ariths = map[Callable]*arith{
+	add:     addArith,
+	sub:     subArith,
+	mul:     mulArith,
+	div:     quoArith,
+	pow:     powArith,
+	residue: modArith,
+}
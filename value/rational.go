@@ -0,0 +1,123 @@
+package value
+
+import (
+	"errors"
+	"math/big"
+)
+
+// newRat creates an exact number. Its Value holds an approximation of it at
+// the precision of env, so that numbers can be read the same way whether or
+// not they are exact.
+func newRat(env *Environment, r *big.Rat) *Num {
+	return &Num{Value: env.float().SetRat(r), Rat: r}
+}
+
+// rat returns the exact value of n, which only rationals and integers kept
+// as an int64 or a big.Int have. A big.Float that happens to hold an integer
+// is the rounded result of some inexact operation, so it has none.
+func (n *Num) rat() (*big.Rat, bool) {
+	if n.Rat != nil {
+		return n.Rat, true
+	} else if n.kind == kindInt {
+		return new(big.Rat).SetInt64(n.i), true
+	} else if n.kind == kindBigInt {
+		return new(big.Rat).SetInt(n.b), true
+	}
+	return nil, false
+}
+
+// ratOf returns the exact value of n when env is in exact mode.
+func ratOf(env *Environment, n *Num) (*big.Rat, bool) {
+	if !env.Exact() {
+		return nil, false
+	}
+	return n.rat()
+}
+
+// rats returns the exact values of lhs and rhs when env is in exact mode and
+// both of them have one. Otherwise the operation is done on floats.
+func rats(env *Environment, lhs, rhs *Num) (*big.Rat, *big.Rat, bool) {
+	x, ok := ratOf(env, lhs)
+	if !ok {
+		return nil, nil, false
+	}
+	y, ok := ratOf(env, rhs)
+	if !ok {
+		return nil, nil, false
+	}
+	return x, y, true
+}
+
+// cmp compares lhs and rhs, exactly when they are both rationals.
+func cmp(env *Environment, lhs, rhs *Num) int {
+	if lhs.kind == kindInt && rhs.kind == kindInt {
+		switch {
+		case lhs.i < rhs.i:
+			return -1
+		case lhs.i > rhs.i:
+			return 1
+		}
+		return 0
+	}
+	if a, ok := lhs.float64(); ok {
+		if b, ok := rhs.float64(); ok {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, y, ok := rats(env, lhs, rhs); ok {
+		return x.Cmp(y)
+	}
+	return lhs.Float().Cmp(rhs.Float())
+}
+
+// ratFloor is the largest integer that is not greater than r.
+func ratFloor(r *big.Rat) *big.Rat {
+	// Euclidean division rounds down, since the denominator is positive.
+	return new(big.Rat).SetInt(new(big.Int).Div(r.Num(), r.Denom()))
+}
+
+func ratCeil(r *big.Rat) *big.Rat {
+	return new(big.Rat).Neg(ratFloor(new(big.Rat).Neg(r)))
+}
+
+// maxRatBits is the most bits that ratPower gives the numerator or the
+// denominator of its result.
+const maxRatBits = 1 << 20
+
+// ratPower raises base to an integer exponent by repeated squaring.
+func ratPower(base *big.Rat, n int64) (*big.Rat, error) {
+	if n < 0 && base.Sign() == 0 {
+		return nil, errors.New("division by zero")
+	}
+
+	// Every factor of base adds at least one bit less than its length, so
+	// this is how long the result is bound to be.
+	bits := base.Num().BitLen()
+	if d := base.Denom().BitLen(); d > bits {
+		bits = d
+	}
+	if bits > 1 {
+		if limit := int64(maxRatBits / (bits - 1)); n > limit || n < -limit {
+			return nil, errors.New("result is out of range")
+		}
+	}
+
+	res := big.NewRat(1, 1)
+	sq := new(big.Rat).Set(base)
+	for k := n; k != 0; k /= 2 {
+		if k%2 != 0 {
+			res.Mul(res, sq)
+		}
+		sq.Mul(sq, sq)
+	}
+	if n < 0 {
+		res.Inv(res)
+	}
+	return res, nil
+}
@@ -0,0 +1,226 @@
+package value
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Callable is implemented by both infix operators and functions, either of
+// which can be folded over a value when it takes two arguments.
+type Callable interface {
+	Value
+	Dispatch(*Environment, ...Value) (Value, error)
+}
+
+// associative holds the built-in operators whose result does not depend on
+// how their arguments are grouped, which are folded from the left instead so
+// that generators are consumed lazily and scans take linear time.
+var associative = map[Callable]bool{
+	add:  true,
+	mul:  true,
+	min_: true,
+	max_: true,
+	lcm:  true,
+	gcd:  true,
+}
+
+// Folding a generator from the right reads all of its items first, and
+// scanning one from the right folds every leading run of its items again, so
+// at most maxFold and maxScan items are read for them. Generators with more
+// items, including infinite ones, are refused rather than read forever.
+const (
+	maxFold = 1 << 20
+	maxScan = 1 << 10
+)
+
+// fold combines items with fn from the right, so that folding 1 2 3 with `-`
+// is `1 - (2 - 3)`, or from the left when fn is associative.
+func fold(env *Environment, fn Callable, items []Value) (Value, error) {
+	if associative[fn] {
+		acc := items[0]
+		for _, item := range items[1:] {
+			next, err := fn.Dispatch(env, acc, item)
+			if err != nil {
+				return nil, err
+			}
+			acc = next
+		}
+		return acc, nil
+	}
+
+	acc := items[len(items)-1]
+	for i := len(items) - 2; i >= 0; i-- {
+		next, err := fn.Dispatch(env, items[i], acc)
+		if err != nil {
+			return nil, err
+		}
+		acc = next
+	}
+	return acc, nil
+}
+
+// Reduce folds fn over the items of val from the right, as APL does, so that
+// `-/ 1 2 3` is `1 - (2 - 3)`. Generators are read to the end before they are
+// folded, and may have at most maxFold items, except by associative operators
+// such as `+`, which fold them from the left as they go. A number reduces to itself, and an array of a higher
+// rank is reduced along its last axis, so `+/` of a matrix is the sum of
+// every row.
+func Reduce(env *Environment, fn Callable, val Value) (Value, error) {
+	switch v := val.(type) {
+	case *Num:
+		return v, nil
+
+	case *Arr:
+		if v.Rank() == 0 {
+			return v, nil
+		} else if op := ariths[fn]; op != nil {
+			if res, ok := op.reduce(env, v, associative[fn]); ok {
+				return res, nil
+			}
+		}
+
+		cells, dims := lastAxis(v)
+		res := make([]Value, len(cells))
+		for i, cell := range cells {
+			if len(cell) == 0 {
+				return nil, errors.New("cannot reduce an empty array")
+			}
+
+			acc, err := fold(env, fn, cell)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = acc
+		}
+
+		if len(dims) == 0 {
+			return res[0], nil
+		}
+		return shaped(dims, res), nil
+
+	case *Gen:
+		if !associative[fn] {
+			items, err := readFold(v)
+			if err != nil {
+				return nil, err
+			} else if len(items) == 0 {
+				return nil, errors.New("cannot reduce an empty generator")
+			}
+			return fold(env, fn, items)
+		}
+
+		it := v.Iter()
+		acc, ok := it.Next()
+		if !ok {
+			if err := it.Err(); err != nil {
+				return nil, err
+			}
+			return nil, errors.New("cannot reduce an empty generator")
+		}
+
+		for {
+			item, ok := it.Next()
+			if !ok {
+				if err := it.Err(); err != nil {
+					return nil, err
+				}
+				return acc, nil
+			}
+
+			res, err := fn.Dispatch(env, acc, item)
+			if err != nil {
+				return nil, err
+			}
+			acc = res
+		}
+	}
+
+	return nil, fmt.Errorf("cannot reduce %s", Ty(val))
+}
+
+// Scan is like Reduce but returns the reduction of every leading run of
+// items, so that `+\ 1 2 3` is `1 3 6` and `-\ 1 2 3` is `1 -1 2`. Scanning
+// a generator produces a new generator.
+func Scan(env *Environment, fn Callable, val Value) (Value, error) {
+	switch v := val.(type) {
+	case *Num:
+		return v, nil
+
+	case *Arr:
+		cells, _ := lastAxis(v)
+		res := &Arr{Shape: v.Shape, Values: make([]Value, 0, v.Len())}
+		for _, cell := range cells {
+			var acc Value
+			for i, item := range cell {
+				var err error
+				switch {
+				case i == 0:
+					acc = item
+				case associative[fn]:
+					acc, err = fn.Dispatch(env, acc, item)
+				default:
+					acc, err = fold(env, fn, cell[:i+1])
+				}
+				if err != nil {
+					return nil, err
+				}
+				res.Values = append(res.Values, acc)
+			}
+		}
+		return pack(res), nil
+
+	case *Gen:
+		if !associative[fn] {
+			return scanRight(env, fn, v), nil
+		}
+		res := v.scan(func(acc, item Value) (Value, error) {
+			return fn.Dispatch(env, acc, item)
+		})
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("cannot scan %s", Ty(val))
+}
+
+// readFold reads every item of a generator that is folded from the right.
+func readFold(g *Gen) ([]Value, error) {
+	var items []Value
+	it := g.Iter()
+	for val, ok := it.Next(); ok; val, ok = it.Next() {
+		if len(items) == maxFold {
+			return nil, tooLong("reduce", maxFold)
+		}
+		items = append(items, val)
+	}
+	return items, it.Err()
+}
+
+func tooLong(verb string, max int) error {
+	return fmt.Errorf("cannot %s more than %d items of a generator with a function that is not associative", verb, max)
+}
+
+// scanRight scans a generator with an operator that is not associative,
+// which has to keep every item read so far to fold them again from the right,
+// and so fails past maxScan items.
+func scanRight(env *Environment, fn Callable, g *Gen) *Gen {
+	return newGen(TUnknown, func() pull {
+		next := g.start()
+		var items []Value
+		return func() (Value, bool, error) {
+			item, ok, err := next()
+			if err != nil || !ok {
+				return nil, false, err
+			}
+
+			if len(items) == maxScan {
+				return nil, false, tooLong("scan", maxScan)
+			}
+			items = append(items, item)
+			acc, err := fold(env, fn, items)
+			if err != nil {
+				return nil, false, err
+			}
+			return acc, true, nil
+		}
+	})
+}
@@ -5,15 +5,32 @@ import (
 	"strings"
 )
 
-type signature string
+// maxArgs is the most arguments a function implemented by a table of
+// handlers can take.
+const maxArgs = 4
+
+// signature is the number and types of the arguments a handler accepts. It
+// is comparable so that looking a handler up does not allocate.
+type signature struct {
+	argc int
+	tys  [maxArgs]ty
+}
 
 func sig(tys ...ty) signature {
-	args := make([]string, len(tys))
-	for i := range tys {
-		args[i] = tys[i].String()
+	if len(tys) > maxArgs {
+		panic(fmt.Sprintf("signature of %d arguments", len(tys)))
 	}
-	s := fmt.Sprintf("%d/%s", len(tys), strings.Join(args, "/"))
-	return signature(s)
+	s := signature{argc: len(tys)}
+	copy(s.tys[:], tys)
+	return s
+}
+
+func (s signature) String() string {
+	args := make([]string, s.argc)
+	for i := range args {
+		args[i] = s.tys[i].String()
+	}
+	return fmt.Sprintf("%d/%s", s.argc, strings.Join(args, "/"))
 }
 
 type ty uint8
@@ -23,9 +40,21 @@ const (
 	TArr
 	TNum
 	TGen
+	TFn
+	TChar
 )
 
+// genOf is the type of a generator whose items are of type elem. Handlers
+// registered under it are preferred over ones for generators of any type.
+func genOf(elem ty) ty {
+	return TGen | elem
+}
+
 func (ty ty) String() string {
+	if ty&TGen != 0 && ty != TGen {
+		return fmt.Sprintf("<generator%s>", ty&^TGen)
+	}
+
 	switch ty {
 	case TArr:
 		return "<array>"
@@ -33,6 +62,10 @@ func (ty ty) String() string {
 		return "<number>"
 	case TGen:
 		return "<generator>"
+	case TFn:
+		return "<function>"
+	case TChar:
+		return "<char>"
 	default:
 		return "<unknown>"
 	}
@@ -46,7 +79,80 @@ func Ty(v Value) ty {
 		return TNum
 	case *Gen:
 		return TGen
+	case *Fn:
+		return TFn
+	case *Char:
+		return TChar
 	default:
 		return TUnknown
 	}
 }
+
+// elemTy returns the type shared by every value in vals, or TUnknown when
+// they are of different types.
+func elemTy(vals []Value) ty {
+	if len(vals) == 0 {
+		return TUnknown
+	}
+	res := Ty(vals[0])
+	for _, val := range vals[1:] {
+		if Ty(val) != res {
+			return TUnknown
+		}
+	}
+	return res
+}
+
+// signatures lists the signatures that a call with vals can be dispatched
+// on, from the most specific to the least. Generators are first matched by
+// the type of their items and then as generators of anything.
+func signatures(vals []Value) []signature {
+	combos := [][]ty{nil}
+	for _, val := range vals {
+		options := []ty{Ty(val)}
+		if gen, ok := val.(*Gen); ok && genOf(gen.elem) != TGen {
+			options = []ty{genOf(gen.elem), TGen}
+		}
+
+		var next [][]ty
+		for _, combo := range combos {
+			for _, option := range options {
+				next = append(next, append(append([]ty{}, combo...), option))
+			}
+		}
+		combos = next
+	}
+
+	sigs := make([]signature, len(combos))
+	for i, combo := range combos {
+		sigs[i] = sig(combo...)
+	}
+	return sigs
+}
+
+// find returns the handler for the most specific signature of vals, along
+// with that signature. Calls without generators of a known item type have a
+// single signature, which is looked up directly.
+func (table fntable) find(vals []Value) (handler, signature) {
+	if len(vals) <= maxArgs {
+		s := signature{argc: len(vals)}
+		typed := false
+		for i, val := range vals {
+			s.tys[i] = Ty(val)
+			if gen, ok := val.(*Gen); ok && genOf(gen.elem) != TGen {
+				typed = true
+			}
+		}
+		if !typed {
+			return table[s], s
+		}
+	}
+
+	sigs := signatures(vals)
+	for _, s := range sigs {
+		if handler, ok := table[s]; ok {
+			return handler, s
+		}
+	}
+	return nil, sigs[0]
+}
@@ -5,6 +5,7 @@ import (
 	"math/big"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type handler func(*Environment, ...Value) (Value, error)
@@ -14,104 +15,313 @@ type Value interface {
 	Stringify() string
 }
 
+// Num is a number. Rat holds its exact value when it is a rational computed
+// in exact mode, in which case Value is an approximation of it. Imag holds
+// the imaginary part of a complex number, whose real part is Value. Numbers
+// made by NewInt and NewNum may be stored as machine values instead, and
+// integers that overflow them as a big.Int, in which case Value is nil and
+// Float has to be used to read them.
 type Num struct {
 	Value *big.Float
+	Rat   *big.Rat
+	Imag  *big.Float
+
+	kind kind
+	i    int64
+	f    float64
+	b    *big.Int
 }
 
 func (n *Num) Stringify() string {
-	return n.Value.Text('g', -1)
+	return n.format(0, true)
 }
 
-type stepper func(Value, int) (next Value, done bool, ok bool)
+// format prints the number with the given number of significant digits, or
+// with as many as it takes to tell it apart from any other number of the
+// same precision when digits is 0. Integers that are kept exact are printed
+// with all of their digits. Rationals are printed as fractions, such as 1/3,
+// when fractions is set, and complex numbers as their real and imaginary
+// parts separated by a J, such as 3J4.
+func (n *Num) format(digits int, fractions bool) string {
+	if n.complex() {
+		return NewNum(n.Value).format(digits, fractions) + "J" + NewNum(n.Imag).format(digits, fractions)
+	} else if n.Rat != nil && fractions {
+		return n.Rat.RatString()
+	} else if n.kind == kindInt {
+		return strconv.FormatInt(n.i, 10)
+	} else if n.kind == kindBigInt {
+		return n.b.String()
+	} else if digits == 0 {
+		return n.Float().Text('g', -1)
+	}
+	return n.Float().Text('g', digits)
+}
 
-type Gen struct {
-	ty    ty
-	done  bool
-	curr  Value
-	next  stepper
-	steps []stepper
+// Char is a single character. Strings are arrays of characters.
+type Char struct {
+	Value rune
 }
 
-func (g *Gen) Stringify() string {
-	return fmt.Sprintf("generator%s", g.ty)
+func (c *Char) Stringify() string {
+	return string(c.Value)
 }
 
-func (g *Gen) With(step stepper) *Gen {
-	return &Gen{
-		ty:    g.ty,
-		done:  g.done,
-		curr:  g.curr,
-		next:  g.next,
-		steps: append(g.steps, step),
+// NewStr creates the array of characters that makes up s.
+func NewStr(s string) *Arr {
+	arr := &Arr{Values: []Value{}}
+	for _, r := range s {
+		arr.Values = append(arr.Values, &Char{Value: r})
 	}
+	return arr
+}
+
+// Arr is an array of any rank. Its items are held in row-major order, and
+// Shape is the length of every axis. A nil Shape is a vector, while an empty
+// one is a scalar that holds a single, usually nested, item.
+//
+// Arrays made up of machine numbers only keep them unboxed, as an []int64 or
+// a []float64, and leave Values nil. Values holds the items of every other
+// array. Len, At and Items read the items however they are stored.
+type Arr struct {
+	Shape  []int
+	Values []Value
+
+	ints   []int64
+	floats []float64
+}
+
+// NewArr creates a vector of vals.
+func NewArr(vals []Value) *Arr {
+	return pack(&Arr{Values: vals})
 }
 
-func (g *Gen) Next() (Value, bool) {
-	res := g.curr
-	if g.done {
-		return res, false
+// Len is the number of items in the array.
+func (a *Arr) Len() int {
+	switch {
+	case a.ints != nil:
+		return len(a.ints)
+	case a.floats != nil:
+		return len(a.floats)
 	}
+	return len(a.Values)
+}
+
+// At returns the item at offset i.
+func (a *Arr) At(i int) Value {
+	switch {
+	case a.ints != nil:
+		return NewInt(a.ints[i])
+	case a.floats != nil:
+		return newFloat64(a.floats[i])
+	}
+	return a.Values[i]
+}
 
-	curr, done, ok := g.next(g.curr, 1)
-	if !ok {
-		return nil, false
+// Items returns every item of the array, which must not be modified.
+func (a *Arr) Items() []Value {
+	if a.ints == nil && a.floats == nil {
+		return a.Values
 	}
+	vals := make([]Value, a.Len())
+	for i := range vals {
+		vals[i] = a.At(i)
+	}
+	return vals
+}
 
-	g.curr = curr
-	g.done = done
-	if g.done {
-		return res, false
+// Dims returns the length of every axis of the array.
+func (a *Arr) Dims() []int {
+	if a.Shape == nil {
+		return []int{a.Len()}
 	}
+	return a.Shape
+}
 
-	for _, step := range g.steps {
-		if g.done {
-			break
-		}
+func (a *Arr) Rank() int {
+	return len(a.Dims())
+}
 
-		val, done, ok := step(res, 1)
-		if !ok {
-			return nil, false
+func (a *Arr) Stringify() string {
+	return a.format(Value.Stringify)
+}
+
+// format lays the array out, using str to print its items.
+func (a *Arr) format(str func(Value) string) string {
+	if a.isStr() {
+		return a.stringifyStr()
+	}
+
+	// Items are right aligned to the widest one, unless there are nested
+	// arrays in the mix, in which case they are only separated by spaces.
+	items := make([]string, a.Len())
+	var width int
+	var nested bool
+	for i, val := range a.Items() {
+		items[i] = formatItem(val, str)
+		if w := utf8.RuneCountInString(items[i]); w > width {
+			width = w
+		}
+		if arr, ok := val.(*Arr); ok && arr.Rank() > 0 {
+			nested = true
 		}
+	}
+	if nested {
+		width = 0
+	}
 
-		res = val
-		if done {
-			return res, false
+	dims := a.Dims()
+	switch len(dims) {
+	case 0:
+		return items[0]
+
+	case 1:
+		var vals []string
+		formatter := fmt.Sprintf("%% %ds", width)
+		for i, item := range items {
+			vals = append(vals, fmt.Sprintf(formatter, item))
+			if (i+1)%10 == 0 {
+				vals = append(vals, "\n ")
+			}
 		}
+		return strings.Join(vals, " ")
+	}
 
-		g.done = done
+	// Matrices are laid out in rows with every column right aligned, and
+	// arrays of a higher rank as a series of matrices.
+	cols := dims[len(dims)-1]
+	rows := dims[len(dims)-2]
+	widths := make([]int, cols)
+	for i, item := range items {
+		if w := utf8.RuneCountInString(item); w > widths[i%cols] {
+			widths[i%cols] = w
+		}
 	}
 
-	if g.done {
-		return res, false
+	var lines []string
+	for r := 0; cols > 0 && r*cols < len(items); r++ {
+		if r > 0 && r%rows == 0 {
+			lines = append(lines, "")
+		}
+
+		row := make([]string, cols)
+		for c := range row {
+			row[c] = fmt.Sprintf(fmt.Sprintf("%% %ds", widths[c]), items[r*cols+c])
+		}
+		lines = append(lines, strings.Join(row, " "))
 	}
-	return res, true
+	return strings.Replace(strings.Join(lines, "\n  "), "\n  \n", "\n\n", -1)
 }
 
-type Arr struct {
-	Values []*Num
+// isStr reports whether the array is made up of characters only.
+func (a *Arr) isStr() bool {
+	if len(a.Values) == 0 {
+		return false
+	}
+	for _, val := range a.Values {
+		if _, ok := val.(*Char); !ok {
+			return false
+		}
+	}
+	return true
 }
 
-func (a *Arr) Stringify() string {
-	var vals []string
-	var max float64
+// stringifyStr lays an array of characters out as text, with a line for
+// every row of a matrix and a blank line between the planes of arrays of a
+// higher rank.
+func (a *Arr) stringifyStr() string {
+	var b strings.Builder
 	for _, val := range a.Values {
-		v, _ := val.Value.Float64()
-		if v > max {
-			max = v
+		b.WriteRune(val.(*Char).Value)
+	}
+
+	dims := a.Dims()
+	if len(dims) < 2 {
+		return b.String()
+	}
+
+	text := []rune(b.String())
+	cols := dims[len(dims)-1]
+	rows := dims[len(dims)-2]
+	var lines []string
+	for r := 0; cols > 0 && r*cols < len(text); r++ {
+		if r > 0 && r%rows == 0 {
+			lines = append(lines, "")
 		}
+		lines = append(lines, string(text[r*cols:(r+1)*cols]))
 	}
-	formatter := fmt.Sprintf("%% %ds", len(strconv.Itoa(int(max))))
-	for i, val := range a.Values {
-		vals = append(vals, fmt.Sprintf(formatter, val.Stringify()))
-		if (i+1)%10 == 0 {
-			vals = append(vals, "\n ")
+	return strings.Replace(strings.Join(lines, "\n  "), "\n  \n", "\n\n", -1)
+}
+
+// stringifyItem formats an item of an array, wrapping nested arrays in
+// parentheses to set them apart from their neighbours.
+func stringifyItem(val Value) string {
+	return formatItem(val, Value.Stringify)
+}
+
+func formatItem(val Value, str func(Value) string) string {
+	if arr, ok := val.(*Arr); ok && arr.Rank() > 0 {
+		return "(" + str(arr) + ")"
+	}
+	return str(val)
+}
+
+// Format is like Stringify but prints numbers with the number of significant
+// digits set by ⎕PP in env, and rationals as fractions or decimals as set by
+// ⎕FRAC.
+func Format(env *Environment, val Value) string {
+	digits, fractions := env.PrintPrecision(), env.Fractions()
+	var str func(Value) string
+	str = func(val Value) string {
+		switch v := val.(type) {
+		case *Num:
+			return v.format(digits, fractions)
+		case *Arr:
+			return v.format(str)
 		}
+		return val.Stringify()
 	}
-	return strings.Join(vals, " ")
+	return str(val)
 }
 
+// Assoc is the direction in which a chain of infix operators with equal
+// precedence is grouped.
+type Assoc uint8
+
+const (
+	AssocLeft Assoc = iota
+	AssocRight
+)
+
+// Operator precedence levels, from loosest to tightest binding. An operator
+// with a zero precedence is treated as PrecDefault.
+const (
+	PrecAssign = iota + 1
+	PrecCatenate
+	PrecLogical
+	PrecCompare
+	PrecRange
+	PrecAdditive
+	PrecMultiplicative
+	PrecPower
+	PrecSelect
+
+	PrecDefault = PrecAdditive
+)
+
 type Op struct {
-	Impl fntable
+	Prec   int
+	Assoc  Assoc
+	Impl   fntable
+	Source string
+
+	proc Proc
+}
+
+// NewOp creates an infix operator that is implemented by proc rather than by
+// a table of handlers. It is used for operators defined in the language, in
+// which case source is their definition.
+func NewOp(source string, proc Proc) *Op {
+	return &Op{Source: source, proc: proc}
 }
 
 func (op *Op) Dispatch(env *Environment, vals ...Value) (Value, error) {
@@ -119,26 +329,46 @@ func (op *Op) Dispatch(env *Environment, vals ...Value) (Value, error) {
 		return nil, fmt.Errorf("expecting 2 arguments but got %d", len(vals))
 	}
 
-	a1, a2 := vals[0], vals[1]
-	t1, t2 := Ty(a1), Ty(a2)
-	handler, ok := op.Impl[sig(t1, t2)]
-	if !ok {
-		return nil, fmt.Errorf("operator does not implement %s", sig(t1, t2))
+	if op.proc != nil {
+		return op.proc(env, vals...)
 	}
 
-	return handler(env, a1, a2)
+	handler, s := op.Impl.find(vals)
+	if handler == nil {
+		return nil, fmt.Errorf("operator does not implement %s", s)
+	}
+	return handler(env, vals...)
 }
 
-func (*Op) Stringify() string {
+func (op *Op) Stringify() string {
+	if op.Source != "" {
+		return op.Source
+	}
 	return "op"
 }
 
+// Proc implements a function or operator that accepts arguments of any type.
+type Proc func(*Environment, ...Value) (Value, error)
+
 type Fn struct {
-	Argc int
-	Impl fntable
+	Argc   int
+	Impl   fntable
+	Source string
+
+	proc Proc
+}
+
+// NewFn creates a function that is implemented by proc rather than by a
+// table of handlers. It is used for functions defined in the language, in
+// which case source is their definition.
+func NewFn(argc int, source string, proc Proc) *Fn {
+	return &Fn{Argc: argc, Source: source, proc: proc}
 }
 
 func (fn *Fn) Stringify() string {
+	if fn.Source != "" {
+		return fn.Source
+	}
 	return fmt.Sprintf("fn/%d", fn.Argc)
 }
 
@@ -148,15 +378,13 @@ func (fn *Fn) Dispatch(env *Environment, vals ...Value) (Value, error) {
 			fn.Argc, len(vals))
 	}
 
-	var tys []ty
-	for _, arg := range vals {
-		tys = append(tys, Ty(arg))
+	if fn.proc != nil {
+		return fn.proc(env, vals...)
 	}
 
-	handler, ok := fn.Impl[sig(tys...)]
-	if !ok {
-		return nil, fmt.Errorf("function does not implement %s", sig(tys...))
+	handler, s := fn.Impl.find(vals)
+	if handler == nil {
+		return nil, fmt.Errorf("function does not implement %s", s)
 	}
-
 	return handler(env, vals...)
 }
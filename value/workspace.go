@@ -0,0 +1,171 @@
+package value
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// workspaceVersion is the version of the file format that Save writes. Load
+// refuses files of any other version.
+const workspaceVersion = 1
+
+// workspace is the file format of a saved environment. Values are held in
+// their JSON encoding, along with the precision of their most precise
+// number so that they are read back exactly, and functions and operators as
+// the source of their definitions.
+type workspace struct {
+	Version int                 `json:"version"`
+	Sys     map[string]int64    `json:"sys,omitempty"`
+	Vals    map[string]savedVal `json:"vals,omitempty"`
+	Fns     map[string]savedFn  `json:"fns,omitempty"`
+	Ops     map[string]string   `json:"ops,omitempty"`
+}
+
+type savedVal struct {
+	Prec  uint            `json:"prec,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+type savedFn struct {
+	Argc   int    `json:"argc"`
+	Source string `json:"source"`
+}
+
+// Compile evaluates the definition of a function or operator in env, giving
+// the function or operator it defines. It is set by the evaluator, which
+// this package cannot import, and is what Load recreates them with.
+var Compile func(env *Environment, source string) (Value, error)
+
+// Save writes the bindings of env to w, leaving out the built-ins that
+// NewEnvironment registers. System variables are only saved when they are
+// not set to their defaults, and functions, operators and generators bound
+// as values, which have no definition to recreate them from, are left out.
+func (env *Environment) Save(w io.Writer) error {
+	ws := workspace{
+		Version: workspaceVersion,
+		Sys:     make(map[string]int64),
+		Vals:    make(map[string]savedVal),
+		Fns:     make(map[string]savedFn),
+		Ops:     make(map[string]string),
+	}
+
+	defaults := NewEnvironment()
+	for id, val := range env.val {
+		if IsSys(id) {
+			if def := defaults.val[id]; def == nil || val.Stringify() != def.Stringify() {
+				ws.Sys[id], _ = val.(*Num).int64()
+			}
+			continue
+		}
+
+		switch val.(type) {
+		case *Fn, *Op, *Gen:
+			continue
+		}
+		data, err := EncodeJSON(val)
+		if err != nil {
+			return fmt.Errorf("cannot save %s: %v", id, err)
+		}
+		ws.Vals[id] = savedVal{Prec: precision(val), Value: data}
+	}
+
+	for id, fn := range env.fns {
+		if fn.Source != "" {
+			ws.Fns[id] = savedFn{Argc: fn.Argc, Source: fn.Source}
+		}
+	}
+	for id, op := range env.ops {
+		if op.Source != "" {
+			ws.Ops[id] = op.Source
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(ws)
+}
+
+// precision is the precision of the most precise number in val that is not
+// a machine value, or 0 when there is none.
+func precision(val Value) uint {
+	var prec uint
+	switch v := val.(type) {
+	case *Num:
+		if v.kind == kindBig && v.Value != nil {
+			prec = v.Value.Prec()
+		}
+		if v.Imag != nil && v.Imag.Prec() > prec {
+			prec = v.Imag.Prec()
+		}
+	case *Arr:
+		for _, item := range v.Items() {
+			if p := precision(item); p > prec {
+				prec = p
+			}
+		}
+	}
+	return prec
+}
+
+// Load reads bindings written by Save into env, replacing any bindings of
+// the same names. Functions and operators are defined before they are
+// compiled, so that their definitions may refer to each other whatever
+// order they were written in.
+func (env *Environment) Load(r io.Reader) error {
+	var ws workspace
+	if err := json.NewDecoder(r).Decode(&ws); err != nil {
+		return fmt.Errorf("invalid workspace: %v", err)
+	} else if ws.Version != workspaceVersion {
+		return fmt.Errorf("unsupported workspace version %d", ws.Version)
+	} else if Compile == nil && (len(ws.Fns) > 0 || len(ws.Ops) > 0) {
+		return errors.New("cannot load functions without an evaluator")
+	}
+
+	for id, n := range ws.Sys {
+		if err := env.SetSys(id, NewInt(n)); err != nil {
+			return err
+		}
+	}
+
+	for id := range ws.Ops {
+		env.SetOp(id, &Op{})
+	}
+	for id, fn := range ws.Fns {
+		env.SetFn(id, &Fn{Argc: fn.Argc})
+	}
+
+	for id, source := range ws.Ops {
+		val, err := Compile(env, source)
+		if err != nil {
+			return fmt.Errorf("cannot load %s: %v", id, err)
+		}
+		op, ok := val.(*Op)
+		if !ok {
+			return fmt.Errorf("cannot load %s: expecting an operator but got %s", id, val.Stringify())
+		}
+		env.SetOp(id, op)
+	}
+	for id, saved := range ws.Fns {
+		val, err := Compile(env, saved.Source)
+		if err != nil {
+			return fmt.Errorf("cannot load %s: %v", id, err)
+		}
+		fn, ok := val.(*Fn)
+		if !ok {
+			return fmt.Errorf("cannot load %s: expecting a function but got %s", id, val.Stringify())
+		}
+		env.SetFn(id, fn)
+	}
+
+	for id, saved := range ws.Vals {
+		val, err := decodeJSON(saved.Value, saved.Prec)
+		if err != nil {
+			return fmt.Errorf("cannot load %s: %v", id, err)
+		}
+		env.SetVal(id, val)
+	}
+	return nil
+}